@@ -1,9 +1,9 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -11,26 +11,63 @@ import (
 	"os/signal"
 	"strconv"
 	"syscall"
+	"time"
 
-	"github.com/crunchydeer30/httpfromtcp/internal/headers"
+	"github.com/crunchydeer30/httpfromtcp/internal/accesslog"
 	"github.com/crunchydeer30/httpfromtcp/internal/request"
 	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/crunchydeer30/httpfromtcp/internal/router"
 	"github.com/crunchydeer30/httpfromtcp/internal/server"
+	"github.com/crunchydeer30/httpfromtcp/internal/vhost"
 )
 
-const port = 42069
+// addr is used unless the process is started by systemd socket activation.
+const addr = ":42069"
+const shutdownTimeout = 15 * time.Second
 
 func main() {
-	server, err := server.Serve(port, handler)
+	rt := router.New()
+	rt.Handle("GET /video", binaryHandler)
+	rt.Handle("GET /httpbin/stream", streamHandler)
+	rt.Handle("/{path...}", handler)
+
+	hosts := vhost.New()
+	hosts.Handle("*", rt.ServeHTTP)
+
+	srv := server.New(hosts.ServeHTTP,
+		server.WithReadHeaderTimeout(5*time.Second),
+		server.WithReadTimeout(30*time.Second),
+		server.WithWriteTimeout(30*time.Second),
+		server.WithIdleTimeout(60*time.Second),
+		server.WithMetricsPath("/metrics"),
+	)
+	srv.Use(accesslog.New(accesslog.WithFormat(accesslog.CombinedFormat)))
+
+	listeners, err := server.SystemdListeners()
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	if len(listeners) == 0 {
+		l, err := server.Listen(addr)
+		if err != nil {
+			log.Fatalf("Error starting server: %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	for _, l := range listeners {
+		go srv.Serve(l)
+		log.Println("Server listening on", l.Addr())
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Fatalf("Error stopping server: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
 
@@ -42,20 +79,29 @@ func handler(w *response.ResponseWriter, req *request.Request) {
 }
 
 func binaryHandler(w *response.ResponseWriter, req *request.Request) {
-	data, err := os.ReadFile("assets/vim.mp4")
+	f, err := os.Open("assets/vim.mp4")
 	if err != nil {
 		w.WriteStatusLine(response.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
-	fmt.Println("data len:", len(data))
+	defer f.Close()
+
 	w.WriteStatusLine(response.StatusOK)
 	w.Headers.Set("Content-Type", "video/mp4")
-	w.Write(data)
+	if _, err := io.Copy(w, f); err != nil {
+		log.Println("error sending video:", err)
+	}
 }
 
 func streamHandler(w *response.ResponseWriter, req *request.Request) {
-	res, err := http.Get("https://httpbin.org/stream/15")
+	upstream, err := http.NewRequestWithContext(req.Context(), http.MethodGet, "https://httpbin.org/stream/15", nil)
+	if err != nil {
+		w.WriteStatusLine(response.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	res, err := http.DefaultClient.Do(upstream)
 	if err != nil {
 		w.WriteStatusLine(response.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
@@ -64,27 +110,27 @@ func streamHandler(w *response.ResponseWriter, req *request.Request) {
 	defer res.Body.Close()
 
 	w.WriteStatusLine(response.StatusOK)
-	w.Headers.Set("Transfer-Encoding", "chunked")
-	w.Headers.Set("content-type", "application/json")
-	w.Headers.Set("Trailer", "X-Content-SHA256, X-Content-Length")
-	w.WriteHeaders()
+	w.Headers.Set("Content-Type", "application/json")
+	w.DeclareTrailer("X-Content-SHA256", "X-Content-Length")
 
-	buf := []byte{}
+	hash := sha256.New()
+	total := 0
 	for {
 		data := make([]byte, 1024)
 		read, err := res.Body.Read(data)
+		if read > 0 {
+			w.Write(data[:read])
+			w.Flush()
+			hash.Write(data[:read])
+			total += read
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				log.Println("error reading upstream body:", err)
 			}
+			break
 		}
-		w.WriteChunkedBody(data[:read])
-		buf = append(buf, data[:read]...)
 	}
-	w.WriteChunkedBodyDone()
-	trailerHeaders := headers.NewHeaders()
-	trailerHeaders.Set("X-Content-Length", strconv.Itoa(len(buf)))
-	sum := sha256.Sum256(buf)
-	trailerHeaders.Set("X-Content-SHA256", hex.EncodeToString(sum[:]))
-	w.WriteTrailers(trailerHeaders)
+	w.SetTrailer("X-Content-Length", strconv.Itoa(total))
+	w.SetTrailer("X-Content-SHA256", hex.EncodeToString(hash.Sum(nil)))
 }
@@ -46,11 +46,16 @@ func handleConnection(conn net.Conn) {
 		r.RequestLine.HttpVersion)
 
 	fmt.Printf("Headers:\n")
-	for k, v := range r.Headers {
+	for k, v := range r.Headers.All() {
 		fmt.Printf("- %s: %s\n", k, v)
 	}
 
-	fmt.Printf("Body:\n%s\n", string(r.Body))
+	body, err := r.ReadBody()
+	if err != nil {
+		fmt.Println("error reading body:", err)
+		return
+	}
+	fmt.Printf("Body:\n%s\n", string(body))
 
 	log.Println("Connection closed:", conn.RemoteAddr())
 }
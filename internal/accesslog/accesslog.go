@@ -0,0 +1,203 @@
+package accesslog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/crunchydeer30/httpfromtcp/internal/server"
+)
+
+type Format int
+
+const (
+	// CommonFormat is the NCSA Common Log Format.
+	CommonFormat Format = iota
+	// CombinedFormat is the Common Log Format followed by the Referer and
+	// User-Agent headers.
+	CombinedFormat
+	// JSONFormat logs one log/slog record per request with every field,
+	// including the duration and request ID that the text formats lack.
+	JSONFormat
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// now is replaced in tests to get stable timestamps and durations.
+var now = time.Now
+
+type logger struct {
+	format          Format
+	out             io.Writer
+	jsonLogger      *slog.Logger
+	requestIDHeader string
+
+	mu sync.Mutex
+}
+
+// Option configures the access log middleware.
+type Option func(*logger)
+
+func WithFormat(format Format) Option {
+	return func(l *logger) { l.format = format }
+}
+
+// WithOutput sets where log lines are written, os.Stdout by default. Use a
+// RotatingFile to log to a file.
+func WithOutput(out io.Writer) Option {
+	return func(l *logger) { l.out = out }
+}
+
+// WithLogger sends JSONFormat records to sl instead of a JSON handler
+// writing to the output.
+func WithLogger(sl *slog.Logger) Option {
+	return func(l *logger) { l.jsonLogger = sl }
+}
+
+// WithRequestIDHeader sets the header carrying the request ID, X-Request-Id
+// by default.
+func WithRequestIDHeader(name string) Option {
+	return func(l *logger) { l.requestIDHeader = name }
+}
+
+// New returns middleware that logs every request once the handler returns,
+// including requests whose handler panics. Those are logged with a 500 if
+// the server can still send one. Requests without a request ID get a
+// random one, which is added to the request headers for the handler and
+// echoed in the response.
+func New(opts ...Option) server.Middleware {
+	l := &logger{
+		format:          CommonFormat,
+		out:             os.Stdout,
+		requestIDHeader: "X-Request-Id",
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.jsonLogger == nil {
+		l.jsonLogger = slog.New(slog.NewJSONHandler(l.out, nil))
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.ResponseWriter, req *request.Request) {
+			start := now()
+			requestID := req.Headers.Get(l.requestIDHeader)
+			if requestID == "" {
+				requestID = newRequestID()
+				req.Headers.Set(l.requestIDHeader, requestID)
+			}
+			w.Headers.Set(l.requestIDHeader, requestID)
+
+			returned := false
+			defer func() {
+				status, bytes := w.StatusCode(), w.BytesWritten()
+				if !returned && !w.Committed() {
+					// The server replaces the response with its own 500.
+					status, bytes = response.StatusInternalServerError, 0
+				}
+				l.log(req, requestID, start, status, bytes)
+			}()
+			next(w, req)
+			returned = true
+		}
+	}
+}
+
+func (l *logger) log(req *request.Request, requestID string, start time.Time, status response.StatusCode, bytes int) {
+	end := now()
+	if l.format == JSONFormat {
+		l.jsonLogger.LogAttrs(context.Background(), slog.LevelInfo, "request",
+			slog.String("method", req.RequestLine.Method),
+			slog.String("target", req.RequestLine.RequestTarget),
+			slog.String("proto", "HTTP/"+req.RequestLine.HttpVersion),
+			slog.Int("status", int(status)),
+			slog.Int("bytes", bytes),
+			slog.Duration("duration", end.Sub(start)),
+			slog.String("remote_addr", req.RemoteAddr),
+			slog.String("user_agent", req.Headers.Get("User-Agent")),
+			slog.String("referer", req.Headers.Get("Referer")),
+			slog.String("request_id", requestID),
+		)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - - [%s] \"%s %s HTTP/%s\" %d %s",
+		orDash(remoteHost(req.RemoteAddr)),
+		start.Format(clfTimeFormat),
+		escape(req.RequestLine.Method),
+		escape(req.RequestLine.RequestTarget),
+		escape(req.RequestLine.HttpVersion),
+		status,
+		orDash(bytesField(bytes)),
+	)
+	if l.format == CombinedFormat {
+		fmt.Fprintf(&b, " \"%s\" \"%s\"",
+			orDash(escape(req.Headers.Get("Referer"))),
+			orDash(escape(req.Headers.Get("User-Agent"))),
+		)
+	}
+	b.WriteString("\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.out, b.String())
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// bytesField follows CLF in logging an empty body as "-".
+func bytesField(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// escape keeps client-controlled values from breaking out of their field
+// by escaping quotes, backslashes and non-printable bytes.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
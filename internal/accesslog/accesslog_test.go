@@ -0,0 +1,134 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/crunchydeer30/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, mw server.Middleware, raw string) *response.ResponseWriter {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.RemoteAddr = "192.0.2.7:51234"
+
+	w := response.NewResponseWriter(&bytes.Buffer{})
+	mw(func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteStatusLine(response.StatusCreated)
+		w.Write([]byte("hello"))
+	})(w, req)
+	return w
+}
+
+func fixedClock(t *testing.T) {
+	start := time.Date(2026, 10, 17, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+	calls := 0
+	now = func() time.Time {
+		calls++
+		return start.Add(time.Duration(calls-1) * 1500 * time.Microsecond)
+	}
+	t.Cleanup(func() { now = time.Now })
+}
+
+func TestCommonFormats(t *testing.T) {
+	fixedClock(t)
+	raw := "POST /items?id=\"1\" HTTP/1.1\r\nHost: localhost\r\nUser-Agent: curl/8.0\r\nX-Request-Id: abc\r\n\r\n"
+
+	// Test: Common Log Format
+	buf := &bytes.Buffer{}
+	w := serve(t, New(WithOutput(buf)), raw)
+	assert.Equal(t, "192.0.2.7 - - [17/Oct/2026:13:55:36 -0700] \"POST /items?id=\\\"1\\\" HTTP/1.1\" 201 5\n", buf.String())
+	assert.Equal(t, "abc", w.Headers.Get("X-Request-Id"))
+
+	// Test: Combined Log Format
+	buf = &bytes.Buffer{}
+	serve(t, New(WithOutput(buf), WithFormat(CombinedFormat)), raw)
+	assert.True(t, strings.HasSuffix(buf.String(), "201 5 \"-\" \"curl/8.0\"\n"))
+}
+
+func TestJSONFormat(t *testing.T) {
+	fixedClock(t)
+	buf := &bytes.Buffer{}
+	w := serve(t, New(WithOutput(buf), WithFormat(JSONFormat)), "GET /json HTTP/1.1\r\nHost: localhost\r\nUser-Agent: curl/8.0\r\n\r\n")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/json", entry["target"])
+	assert.Equal(t, float64(201), entry["status"])
+	assert.Equal(t, float64(5), entry["bytes"])
+	assert.Equal(t, float64(1500*time.Microsecond), entry["duration"])
+	assert.Equal(t, "192.0.2.7:51234", entry["remote_addr"])
+	assert.Equal(t, "curl/8.0", entry["user_agent"])
+
+	// Test: A request ID is generated when missing
+	assert.Len(t, entry["request_id"], 16)
+	assert.Equal(t, entry["request_id"], w.Headers.Get("X-Request-Id"))
+}
+
+func TestPanickingHandler(t *testing.T) {
+	fixedClock(t)
+	req, err := request.RequestFromReader(strings.NewReader("GET /boom HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	handler := func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteStatusLine(response.StatusCreated)
+		w.Write([]byte("partial"))
+		if req.Headers.Get("X-Flush") != "" {
+			w.Flush()
+		}
+		panic("boom")
+	}
+
+	// Test: A panic before the response is sent is logged as a 500
+	buf := &bytes.Buffer{}
+	w := response.NewResponseWriter(&bytes.Buffer{})
+	assert.Panics(t, func() { New(WithOutput(buf))(handler)(w, req) })
+	assert.Contains(t, buf.String(), "\"GET /boom HTTP/1.1\" 500 -\n")
+
+	// Test: A panic after the response is sent keeps its status
+	buf = &bytes.Buffer{}
+	req.Headers.Set("X-Flush", "1")
+	w = response.NewResponseWriter(&bytes.Buffer{})
+	assert.Panics(t, func() { New(WithOutput(buf))(handler)(w, req) })
+	assert.Contains(t, buf.String(), "\"GET /boom HTTP/1.1\" 201 7\n")
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "fourth\n", read(path))
+	assert.Equal(t, "third\n", read(path+".1"))
+	assert.Equal(t, "second\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// Test: Reopening appends to the current file
+	require.NoError(t, f.Close())
+	f, err = OpenRotatingFile(path, 100, 2)
+	require.NoError(t, err)
+	f.Write([]byte("fifth\n"))
+	assert.Equal(t, "fourth\nfifth\n", read(path))
+}
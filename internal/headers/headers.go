@@ -2,6 +2,7 @@ package headers
 
 import (
 	"fmt"
+	"iter"
 	"strings"
 	"unicode"
 )
@@ -10,41 +11,104 @@ const CRLF = "\r\n"
 
 var ErrMalformedHeader = fmt.Errorf("malformed header")
 
-type Headers map[string]string
+type field struct {
+	name  string
+	value string
+}
 
-func (h Headers) Get(key string) string {
-	k := strings.ToLower(key)
+// Headers is an ordered list of header fields. Lookups are
+// case-insensitive, but every field keeps the name exactly as it was
+// received or set, and repeated fields are kept as separate values.
+type Headers struct {
+	fields []field
+}
 
-	if value, ok := h[k]; ok {
-		return value
+func NewHeaders() *Headers {
+	return &Headers{}
+}
+
+// Get returns the values of key combined into one comma-separated value,
+// or "" if the field is not present. Use Values for fields such as
+// Set-Cookie that cannot be combined.
+func (h *Headers) Get(key string) string {
+	return strings.Join(h.Values(key), ", ")
+}
+
+// Values returns every value of key in the order they were added.
+func (h *Headers) Values(key string) []string {
+	if h == nil {
+		return nil
+	}
+
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			values = append(values, f.value)
+		}
 	}
-	return ""
+	return values
 }
 
-func (h Headers) Set(key string, value string) {
-	k := strings.ToLower(key)
-	if _, ok := h[k]; ok {
-		h[k] = h[k] + ", " + value
-	} else {
-		h[k] = value
+func (h *Headers) Has(key string) bool {
+	return len(h.Values(key)) > 0
+}
+
+// Add appends a value for key, keeping any existing ones.
+func (h *Headers) Add(key string, value string) {
+	h.fields = append(h.fields, field{name: key, value: value})
+}
+
+// Set replaces every value of key with value. The field keeps the position
+// of its first occurrence.
+func (h *Headers) Set(key string, value string) {
+	for i, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			h.fields[i].value = value
+			h.fields = append(h.fields[:i+1], deleteFields(h.fields[i+1:], key)...)
+			return
+		}
 	}
+	h.Add(key, value)
 }
 
-func (h Headers) Replace(key string, value string) {
-	k := strings.ToLower(key)
-	h[k] = value
+func (h *Headers) Del(key string) {
+	h.fields = deleteFields(h.fields, key)
 }
 
-func (h Headers) Delete(key string) {
-	k := strings.ToLower(key)
-	delete(h, k)
+func deleteFields(fields []field, key string) []field {
+	kept := fields[:0]
+	for _, f := range fields {
+		if !strings.EqualFold(f.name, key) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
 }
 
-func NewHeaders() Headers {
-	return make(Headers)
+// All iterates over the fields in order, yielding each name with its
+// original case.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of fields, counting repeated ones separately.
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.fields)
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
 	idx := strings.Index(string(data), CRLF)
 
 	if idx == -1 {
@@ -61,24 +125,38 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 0, false, ErrMalformedHeader
 	}
 
-	key := strings.TrimLeft(headerLine[:colonIdx], " ")
+	// Leading whitespace is either obs-fold or whitespace after the start
+	// line, and whitespace before the colon is forbidden; both are caught
+	// by the token check on the name.
+	key := headerLine[:colonIdx]
 	if !isValidHeader(key) {
 		return 0, false, ErrMalformedHeader
 	}
 
-	value := strings.TrimSpace(headerLine[colonIdx+1:])
+	value := strings.Trim(headerLine[colonIdx+1:], " \t")
+	if !isValidValue(value) {
+		return 0, false, ErrMalformedHeader
+	}
 
-	h.Set(key, value)
+	h.Add(key, value)
 	return idx + len(CRLF), false, nil
 }
 
+// IsToken reports whether s is a non-empty RFC 9110 token, the syntax
+// shared by field names and request methods.
+func IsToken(s string) bool {
+	return isValidHeader(s)
+}
+
 func isValidHeader(key string) bool {
-	if strings.Contains(key, " ") {
+	if key == "" {
 		return false
 	}
 
 	for _, r := range key {
 		switch {
+		case r > unicode.MaxASCII:
+			return false
 		case unicode.IsUpper(r), unicode.IsLower(r), unicode.IsDigit(r):
 			continue
 		case strings.ContainsRune("!#$%&'*+-.^_`|~", r):
@@ -90,3 +168,15 @@ func isValidHeader(key string) bool {
 	return true
 
 }
+
+// isValidValue rejects control characters other than HTAB, which covers
+// bare CR, bare LF and NUL.
+func isValidValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -35,14 +35,22 @@ func TestHeadersParser(t *testing.T) {
 	assert.Equal(t, 32, n)
 	assert.False(t, done)
 
-	// Test valid single header with extra whitespace
+	// Test valid single header with extra whitespace around the value
 	headers = NewHeaders()
-	data = []byte("   Content-Type:    application/json  \r\n\r\n")
+	data = []byte("Content-Type:  \t application/json \t \r\n\r\n")
 	n, done, err = headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
 	assert.Equal(t, "application/json", headers.Get("Content-Type"))
-	assert.Equal(t, 40, n)
+	assert.Equal(t, 38, n)
+	assert.False(t, done)
+
+	// Test: Leading whitespace (obs-fold) is rejected
+	headers = NewHeaders()
+	data = []byte("   Content-Type:    application/json  \r\n\r\n")
+	n, done, err = headers.Parse(data)
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
 	assert.False(t, done)
 
 	// Test 2 headers
@@ -109,3 +117,46 @@ func TestHeaders_MultipleValuesForSameHeader(t *testing.T) {
 	assert.Equal(t, "val1, val2, val3", headers.Get("key"))
 	assert.True(t, done)
 }
+
+func TestHeaders_OrderedMultiValue(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("Set-Cookie", "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT")
+	headers.Add("Content-Type", "text/plain")
+	headers.Add("set-cookie", "b=2")
+
+	// Test: Values are kept apart and in order
+	assert.Equal(t, []string{"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}, headers.Values("SET-COOKIE"))
+	assert.Equal(t, "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT, b=2", headers.Get("Set-Cookie"))
+	assert.Equal(t, 3, headers.Len())
+	assert.True(t, headers.Has("content-type"))
+	assert.False(t, headers.Has("Host"))
+
+	// Test: Iteration keeps insertion order and original case
+	var names []string
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Set-Cookie", "Content-Type", "set-cookie"}, names)
+
+	// Test: Set replaces every value in place
+	headers.Set("SET-COOKIE", "c=3")
+	assert.Equal(t, []string{"c=3"}, headers.Values("Set-Cookie"))
+	names = nil
+	for name, value := range headers.All() {
+		names = append(names, name+"="+value)
+	}
+	assert.Equal(t, []string{"Set-Cookie=c=3", "Content-Type=text/plain"}, names)
+
+	// Test: Del removes every value
+	headers.Del("set-cookie")
+	assert.Nil(t, headers.Values("Set-Cookie"))
+	assert.Equal(t, 1, headers.Len())
+
+	// Test: Parsed names keep their case
+	headers = NewHeaders()
+	_, _, err := headers.Parse([]byte("X-Request-ID: 42\r\n"))
+	require.NoError(t, err)
+	for name := range headers.All() {
+		assert.Equal(t, "X-Request-ID", name)
+	}
+}
@@ -0,0 +1,155 @@
+package request
+
+import (
+	"fmt"
+	"io"
+)
+
+var ErrBodyClosed = fmt.Errorf("read on closed body")
+
+// ErrBodyNotDiscarded is returned by Close when more of the body is left
+// than it is willing to read. The connection cannot carry another request.
+var ErrBodyNotDiscarded = fmt.Errorf("unread body too large to discard")
+
+// MAX_DISCARD_BYTES bounds how much of an unread body Close reads so that
+// a client cannot keep the server busy with an upload nobody wants.
+const MAX_DISCARD_BYTES = 256 << 10
+
+// connReader buffers bytes read from the underlying connection so that the
+// parser can work on whole lines while the body is still streamed lazily.
+type connReader struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	eof         bool
+}
+
+func newConnReader(reader io.Reader) *connReader {
+	return &connReader{
+		reader: reader,
+		buf:    make([]byte, BUFFER_SIZE),
+	}
+}
+
+func (c *connReader) bytes() []byte {
+	return c.buf[:c.readToIndex]
+}
+
+func (c *connReader) fill() error {
+	if c.readToIndex >= len(c.buf) {
+		newBuf := make([]byte, len(c.buf)*2)
+		copy(newBuf, c.buf[:c.readToIndex])
+		c.buf = newBuf
+	}
+
+	n, err := c.reader.Read(c.buf[c.readToIndex:])
+	c.readToIndex += n
+	if err == io.EOF {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+func (c *connReader) consume(n int) {
+	copy(c.buf, c.buf[n:c.readToIndex])
+	c.readToIndex -= n
+}
+
+// body decodes the message body on demand, pulling from the connection only
+// when the handler asks for more data.
+type body struct {
+	req    *Request
+	src    *connReader
+	err    error
+	closed bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyClosed
+	}
+
+	r := b.req
+	for len(r.pending) == 0 {
+		if r.State == DONE {
+			return 0, io.EOF
+		}
+		if b.err != nil {
+			return 0, b.err
+		}
+		if size := b.directSize(len(p)); size > 0 {
+			n, err := b.readDirect(p[:size])
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := r.step(b.src); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[:copy(r.pending, r.pending[n:])]
+	return n, nil
+}
+
+// directSize returns how much of p can be read straight from the
+// connection: body data is due next and none of it is buffered yet.
+func (b *body) directSize(max int) int {
+	if len(b.src.bytes()) > 0 || b.src.eof {
+		return 0
+	}
+	r := b.req
+	switch r.State {
+	case PARSING_BODY:
+		return min(max, r.contentLength-r.bodyRead)
+	case PARSING_CHUNK_DATA:
+		return min(max, r.chunkRemaining)
+	}
+	return 0
+}
+
+// readDirect reads body data from the connection into p, sparing large
+// bodies the small reads and copies of the parse buffer.
+func (b *body) readDirect(p []byte) (int, error) {
+	r := b.req
+	n, err := b.src.reader.Read(p)
+	r.consumed += n
+	r.bodyRead += n
+	if r.State == PARSING_CHUNK_DATA {
+		r.chunkRemaining -= n
+	} else if r.bodyRead == r.contentLength {
+		r.State = DONE
+	}
+
+	if err == io.EOF {
+		// A body cut short is reported by the parser on the next read.
+		b.src.eof = true
+		return n, nil
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close discards whatever is left of the body so that the connection can
+// carry the next request, and stops further reads. It does not close the
+// connection. If more than MAX_DISCARD_BYTES are left it gives up with
+// ErrBodyNotDiscarded.
+func (b *body) Close() error {
+	if b.closed {
+		return nil
+	}
+
+	_, err := io.Copy(io.Discard, io.LimitReader(b, MAX_DISCARD_BYTES))
+	b.closed = true
+	if err == nil && (b.req.State != DONE || len(b.req.pending) > 0) {
+		b.err = ErrBodyNotDiscarded
+		return ErrBodyNotDiscarded
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ParseError describes why a request could not be parsed and which status
+// the server should answer with.
+type ParseError struct {
+	Err error
+	// Status is the suggested response status code.
+	Status int
+	// State is the parser state the error happened in.
+	State parserStatus
+	// Offset is the number of bytes of the request consumed before the
+	// element that failed.
+	Offset int
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v at byte %d while %s", e.Err, e.Offset, e.State)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (r *Request) parseError(err error) *ParseError {
+	return &ParseError{
+		Err:    err,
+		Status: statusFor(err),
+		State:  r.State,
+		Offset: r.consumed,
+	}
+}
+
+func statusFor(err error) int {
+	switch {
+	case errors.Is(err, ErrUnsupportedHttpMethod),
+		errors.Is(err, ErrUnsupportedTransferEncoding):
+		return 501
+	case errors.Is(err, ErrUnsupportedHttpVersion):
+		return 505
+	case errors.Is(err, ErrRequestLineTooLong):
+		return 414
+	case errors.Is(err, ErrHeaderLineTooLong),
+		errors.Is(err, ErrHeadersTooLarge),
+		errors.Is(err, ErrTooManyHeaders):
+		return 431
+	case errors.Is(err, ErrBodyTooLong):
+		return 413
+	case errors.Is(err, ErrUnsupportedExpectation):
+		return 417
+	default:
+		return 400
+	}
+}
@@ -0,0 +1,88 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+var ErrMissingHost = fmt.Errorf("missing host header")
+var ErrDuplicateHost = fmt.Errorf("duplicate host header")
+var ErrInvalidHost = fmt.Errorf("invalid host header")
+
+const HOST_HEADER = "host"
+
+// Host returns the host the request is addressed to, possibly with a port.
+// The authority of an absolute-form or authority-form target takes
+// precedence over the Host header, as RFC 9112 section 3.2.2 requires.
+func (r *Request) Host() string {
+	if r.URL != nil && r.URL.Host != "" {
+		return r.URL.Host
+	}
+	return r.Headers.Get(HOST_HEADER)
+}
+
+// checkHost enforces RFC 9112 section 3.2: an HTTP/1.1 request carries
+// exactly one Host field, and its value is a valid uri-host with an
+// optional port. HTTP/1.0 requests may leave it out.
+func (r Request) checkHost() error {
+	values := r.Headers.Values(HOST_HEADER)
+	switch {
+	case len(values) == 0 && r.RequestLine.HttpVersion == "1.0":
+		return nil
+	case len(values) == 0:
+		return ErrMissingHost
+	case len(values) > 1:
+		return ErrDuplicateHost
+	case !validHost(values[0]):
+		return ErrInvalidHost
+	}
+	return nil
+}
+
+// validHost reports whether host is an IP literal, IPv4 address or
+// registered name optionally followed by a port. An empty host is valid.
+func validHost(host string) bool {
+	if host == "" {
+		return true
+	}
+
+	var name, port string
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end == -1 {
+			return false
+		}
+		literal := host[1:end]
+		if literal == "" || strings.Trim(literal, "0123456789abcdefABCDEF:.") != "" {
+			return false
+		}
+		rest := host[end+1:]
+		if rest != "" && !strings.HasPrefix(rest, ":") {
+			return false
+		}
+		port = strings.TrimPrefix(rest, ":")
+	} else {
+		name, port, _ = strings.Cut(host, ":")
+		if name == "" || !isRegName(name) {
+			return false
+		}
+	}
+	return strings.Trim(port, "0123456789") == ""
+}
+
+// isRegName reports whether s only contains unreserved characters,
+// sub-delims and percent-encoded octets, see RFC 3986 section 3.2.2.
+func isRegName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("-._~!$&'()*+,;=", c) != -1:
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
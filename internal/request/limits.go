@@ -0,0 +1,86 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/headers"
+)
+
+var ErrRequestLineTooLong = fmt.Errorf("request line too long")
+var ErrHeaderLineTooLong = fmt.Errorf("header line too long")
+var ErrHeadersTooLarge = fmt.Errorf("headers too large")
+var ErrTooManyHeaders = fmt.Errorf("too many headers")
+
+// Limits bounds how much a client can make the parser buffer. A zero field
+// takes its value from DefaultLimits, where zero means no limit.
+type Limits struct {
+	MaxRequestLineBytes int
+	// MaxHeaderLineBytes also bounds trailer and chunk-size lines.
+	MaxHeaderLineBytes int
+	// MaxHeaderBytes bounds the header section, and separately the
+	// trailer section of a chunked body.
+	MaxHeaderBytes int
+	MaxHeaderCount int
+	// MaxBodyBytes bounds the decoded body.
+	MaxBodyBytes int
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderLineBytes:  8 << 10,
+	MaxHeaderBytes:      64 << 10,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        0,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineBytes == 0 {
+		l.MaxRequestLineBytes = DefaultLimits.MaxRequestLineBytes
+	}
+	if l.MaxHeaderLineBytes == 0 {
+		l.MaxHeaderLineBytes = DefaultLimits.MaxHeaderLineBytes
+	}
+	if l.MaxHeaderBytes == 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount == 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = DefaultLimits.MaxBodyBytes
+	}
+	return l
+}
+
+// lineTooLong reports whether the line at the start of data is, or is
+// bound to become, longer than max bytes.
+func lineTooLong(data []byte, max int) bool {
+	if max <= 0 {
+		return false
+	}
+	idx := bytes.Index(data, []byte(CRLF))
+	if idx == -1 {
+		return len(data) > max
+	}
+	return idx > max
+}
+
+// checkFieldSection enforces the header limits on a header or trailer
+// section that has grown by n bytes.
+func (r *Request) checkFieldSection(h *headers.Headers, n int) error {
+	r.sectionBytes += n
+	if r.limits.MaxHeaderBytes > 0 && r.sectionBytes > r.limits.MaxHeaderBytes {
+		return ErrHeadersTooLarge
+	}
+	if r.limits.MaxHeaderCount > 0 && h.Len() > r.limits.MaxHeaderCount {
+		return ErrTooManyHeaders
+	}
+	return nil
+}
+
+// bodyTooLong reports whether n more body bytes would exceed the limit.
+// It subtracts rather than adds so that a huge chunk size cannot overflow.
+func (r *Request) bodyTooLong(n int) bool {
+	return r.limits.MaxBodyBytes > 0 && n > r.limits.MaxBodyBytes-r.bodyRead
+}
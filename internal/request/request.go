@@ -1,6 +1,9 @@
 package request
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -17,25 +20,65 @@ var ErrUnsupportedHttpMethod = fmt.Errorf("unsupported http method")
 var ErrIncompleteData = fmt.Errorf("incomplete data")
 var ErrBodyTooLong = fmt.Errorf("body too long")
 var ErrInvalidContentLength = fmt.Errorf("invalid content length")
+var ErrMalformedChunk = fmt.Errorf("malformed chunk")
+var ErrConflictingContentLength = fmt.Errorf("conflicting content length values")
+var ErrConflictingFraming = fmt.Errorf("both content length and transfer encoding present")
+var ErrInvalidTransferEncoding = fmt.Errorf("invalid transfer encoding")
+var ErrUnsupportedTransferEncoding = fmt.Errorf("unsupported transfer encoding")
+var ErrUnsupportedExpectation = fmt.Errorf("unsupported expectation")
 
 type parserStatus string
 
+// String returns the state as it appears in ParseError messages.
+func (s parserStatus) String() string {
+	return strings.ReplaceAll(string(s), "_", " ")
+}
+
 const (
 	INITIALIZED     parserStatus = "initialized"
 	DONE            parserStatus = "done"
 	PARSING_HEADERS parserStatus = "parsing_headers"
 	PARSING_BODY    parserStatus = "parsing_body"
+
+	PARSING_CHUNK_SIZE parserStatus = "parsing_chunk_size"
+	PARSING_CHUNK_DATA parserStatus = "parsing_chunk_data"
+	PARSING_TRAILERS   parserStatus = "parsing_trailers"
 )
 
 const BUFFER_SIZE = 128
 const CRLF = "\r\n"
 const CONTENT_LENGTH_HEADER = "content-length"
+const TRANSFER_ENCODING_HEADER = "transfer-encoding"
+const CONNECTION_HEADER = "connection"
+const EXPECT_HEADER = "expect"
 
 type Request struct {
 	RequestLine RequestLine
 	State       parserStatus
-	Headers     headers.Headers
-	Body        []byte
+	// URL is the request target parsed from RequestLine.RequestTarget.
+	URL     *URL
+	Headers *headers.Headers
+	Body    io.ReadCloser
+	// Trailers are only populated once a chunked Body has been read to EOF.
+	Trailers *headers.Headers
+	// RemoteAddr is the network address of the client, set by the server.
+	RemoteAddr string
+	// TLS holds the negotiated version, cipher suite and peer certificates
+	// for requests received over TLS, and is nil otherwise.
+	TLS *tls.ConnectionState
+
+	ctx          context.Context
+	pathValues   map[string]string
+	limits       Limits
+	sectionBytes int
+	consumed     int
+
+	allowExtensionMethods bool
+
+	contentLength  int
+	bodyRead       int
+	chunkRemaining int
+	pending        []byte
 }
 
 type RequestLine struct {
@@ -44,98 +87,211 @@ type RequestLine struct {
 	Method        string
 }
 
+// Methods are the standard methods from RFC 9110 section 9 and RFC 5789.
+var Methods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH"}
+
+// ValidMethod reports whether the method is one of the standard Methods.
+// Method names are case-sensitive.
 func (r *RequestLine) ValidMethod() bool {
-	methods := []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
-	return slices.Contains(methods, r.Method)
+	return slices.Contains(Methods, r.Method)
 }
 
 func (r *RequestLine) ValidHttpVersion() bool {
-	validVersions := []string{"1.1"}
+	validVersions := []string{"1.0", "1.1"}
 	return slices.Contains(validVersions, r.HttpVersion)
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	readToIndex := 0
+	return NewReader(reader).ReadRequest()
+}
+
+// Reader reads consecutive requests from a single connection. Bytes that
+// belong to a pipelined request are kept buffered until it is read.
+type Reader struct {
+	// Limits applies to every request read after it is set.
+	Limits Limits
+	// AllowExtensionMethods accepts any method that is a valid token, not
+	// just the standard Methods. Unknown methods are otherwise refused
+	// with ErrUnsupportedHttpMethod.
+	AllowExtensionMethods bool
+
+	src  *connReader
+	last *Request
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{src: newConnReader(reader)}
+}
+
+// ReadRequest parses the next request line and headers. Any unread body of
+// the previous request is discarded first. It returns io.EOF if the
+// connection was closed cleanly between requests.
+func (rd *Reader) ReadRequest() (*Request, error) {
+	if err := rd.discardLast(); err != nil {
+		return nil, err
+	}
+
 	r := &Request{
 		RequestLine: RequestLine{},
 		State:       INITIALIZED,
 		Headers:     headers.NewHeaders(),
-	}
+		Trailers:    headers.NewHeaders(),
+		limits:      rd.Limits.withDefaults(),
 
-	buf := make([]byte, BUFFER_SIZE)
-	eofReached := false
-
-	for r.State != DONE {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf[:readToIndex])
-			buf = newBuf
-		}
+		allowExtensionMethods: rd.AllowExtensionMethods,
+	}
 
-		n, err := reader.Read(buf[readToIndex:])
-		if err != nil && err != io.EOF {
+	for !r.headersParsed() {
+		if err := r.step(rd.src); err != nil {
 			return nil, err
 		}
-		if err == io.EOF {
-			eofReached = true
-		}
-		readToIndex += n
+	}
 
-		parsed, err := r.parse(buf[:readToIndex])
-		if err != nil {
-			return nil, err
-		}
+	r.Body = &body{req: r, src: rd.src}
+	rd.last = r
+	return r, nil
+}
 
-		if parsed == 0 && eofReached && r.State != DONE {
-			if r.State == PARSING_BODY {
-				contentLength, err := r.getContentLength()
-				if err != nil {
-					return nil, err
-				}
-				if contentLength > len(r.Body) {
-					return nil, ErrIncompleteData
-				}
-			} else {
-				return nil, ErrIncompleteData
-			}
+// Wait blocks until the first bytes of the next request are available. It
+// returns io.EOF if the connection is closed cleanly first.
+func (rd *Reader) Wait() error {
+	if err := rd.discardLast(); err != nil {
+		return err
+	}
+
+	for len(rd.src.bytes()) == 0 {
+		if rd.src.eof {
+			return io.EOF
 		}
-		if eofReached && readToIndex == 0 {
-			r.State = DONE
-			break
+		if err := rd.src.fill(); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if parsed > 0 {
-			copy(buf, buf[parsed:readToIndex])
-			readToIndex -= parsed
-		}
+// Buffered returns the number of bytes already read from the connection
+// that belong to requests not yet parsed.
+func (rd *Reader) Buffered() int {
+	if rd.last != nil && rd.last.State != DONE {
+		return 0
 	}
+	return len(rd.src.bytes())
+}
 
-	return r, nil
+func (rd *Reader) discardLast() error {
+	if rd.last == nil {
+		return nil
+	}
+	err := rd.last.Body.Close()
+	rd.last = nil
+	return err
 }
 
-func (r *Request) parse(data []byte) (int, error) {
-	totalBytesParsed := 0
-	for r.State != DONE {
-		if totalBytesParsed >= len(data) {
-			break
-		}
+// Context returns the request context. For requests served by a server it
+// is cancelled when the client goes away, the handler returns, or the
+// server is closed.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
 
-		n, err := r.parseSingle(data[totalBytesParsed:])
-		if err != nil {
-			return 0, err
-		}
-		if n == 0 {
-			break
+// WithContext returns a shallow copy of r with its context changed to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// PathValue returns the value of the named path parameter set by a router,
+// or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = map[string]string{}
+	}
+	r.pathValues[name] = value
+}
+
+// KeepAlive reports whether the client allows the connection to be reused
+// after this request. HTTP/1.1 connections persist unless the client sends
+// "Connection: close"; HTTP/1.0 ones only if it sends "Connection:
+// keep-alive".
+func (r *Request) KeepAlive() bool {
+	if r.hasConnectionOption("close") {
+		return false
+	}
+	if r.RequestLine.HttpVersion == "1.0" {
+		return r.hasConnectionOption("keep-alive")
+	}
+	return true
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue"
+// and waits for a 100 Continue before sending the body.
+func (r *Request) ExpectsContinue() bool {
+	return r.RequestLine.HttpVersion != "1.0" && r.Headers.Has(EXPECT_HEADER)
+}
+
+func (r *Request) hasConnectionOption(option string) bool {
+	for _, token := range strings.Split(r.Headers.Get(CONNECTION_HEADER), ",") {
+		if strings.EqualFold(strings.TrimSpace(token), option) {
+			return true
 		}
-		totalBytesParsed += n
+	}
+	return false
+}
+
+// ReadBody reads the rest of the body into memory. It is the buffered
+// equivalent of reading r.Body to EOF.
+func (r *Request) ReadBody() ([]byte, error) {
+	return io.ReadAll(r.Body)
+}
+
+func (r *Request) headersParsed() bool {
+	return r.State != INITIALIZED && r.State != PARSING_HEADERS
+}
+
+// step feeds the buffered data to the parser once, reading more from src
+// when the parser cannot make progress with what it already has.
+func (r *Request) step(src *connReader) error {
+	state := r.State
+	n, err := r.parseSingle(src.bytes())
+	if err != nil {
+		return r.parseError(err)
+	}
+	if n > 0 {
+		src.consume(n)
+		r.consumed += n
+		return nil
+	}
+	if r.State != state {
+		return nil
 	}
 
-	return totalBytesParsed, nil
+	if src.eof {
+		if r.State == INITIALIZED && len(src.bytes()) == 0 {
+			return io.EOF
+		}
+		return r.parseError(ErrIncompleteData)
+	}
+	return src.fill()
 }
 
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.State {
 	case INITIALIZED:
+		if lineTooLong(data, r.limits.MaxRequestLineBytes) {
+			return 0, ErrRequestLineTooLong
+		}
 		n, err := r.parseRequestLine(data)
 		if err != nil {
 			return 0, err
@@ -146,11 +302,32 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		r.State = PARSING_HEADERS
 		return n, nil
 	case PARSING_HEADERS:
+		if lineTooLong(data, r.limits.MaxHeaderLineBytes) {
+			return 0, ErrHeaderLineTooLong
+		}
 		n, done, err := r.Headers.Parse(data)
 		if err != nil {
 			return 0, err
 		}
+		if err := r.checkFieldSection(r.Headers, n); err != nil {
+			return 0, err
+		}
 		if done {
+			r.sectionBytes = 0
+			if err := r.checkHost(); err != nil {
+				return 0, err
+			}
+			if err := r.checkExpect(); err != nil {
+				return 0, err
+			}
+			chunked, err := r.isChunked()
+			if err != nil {
+				return 0, err
+			}
+			if chunked {
+				r.State = PARSING_CHUNK_SIZE
+				return n, nil
+			}
 			contentLength, err := r.getContentLength()
 			if err != nil {
 				return 0, err
@@ -159,37 +336,82 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 				r.State = DONE
 				return n, nil
 			}
+			if r.bodyTooLong(contentLength) {
+				return 0, ErrBodyTooLong
+			}
+			r.contentLength = contentLength
 			r.State = PARSING_BODY
 			return n, nil
 		}
 		return n, nil
 	case PARSING_BODY:
-		contentLength, err := r.getContentLength()
+		remaining := r.contentLength - r.bodyRead
+		if remaining == 0 {
+			r.State = DONE
+			return 0, nil
+		}
+
+		toConsume := min(len(data), remaining)
+		r.pending = append(r.pending, data[:toConsume]...)
+		r.bodyRead += toConsume
+
+		if r.bodyRead == r.contentLength {
+			r.State = DONE
+		}
+		return toConsume, nil
+	case PARSING_CHUNK_SIZE:
+		if lineTooLong(data, r.limits.MaxHeaderLineBytes) {
+			return 0, ErrMalformedChunk
+		}
+		n, size, err := parseChunkSize(data)
 		if err != nil {
 			return 0, err
 		}
-		if contentLength == 0 {
-			r.State = DONE
+		if n == 0 {
 			return 0, nil
 		}
-
-		remaining := contentLength - len(r.Body)
-		toConsume := len(data)
-		if toConsume > remaining {
-			toConsume = remaining
+		if size == 0 {
+			r.State = PARSING_TRAILERS
+			return n, nil
+		}
+		if r.bodyTooLong(size) {
+			return 0, ErrBodyTooLong
+		}
+		r.chunkRemaining = size
+		r.State = PARSING_CHUNK_DATA
+		return n, nil
+	case PARSING_CHUNK_DATA:
+		if r.chunkRemaining == 0 {
+			if len(data) < len(CRLF) {
+				return 0, nil
+			}
+			if !bytes.HasPrefix(data, []byte(CRLF)) {
+				return 0, ErrMalformedChunk
+			}
+			r.State = PARSING_CHUNK_SIZE
+			return len(CRLF), nil
 		}
 
-		r.Body = append(r.Body, data[:toConsume]...)
-
-		if len(r.Body) > contentLength {
-			return len(data), ErrBodyTooLong
+		toConsume := min(len(data), r.chunkRemaining)
+		r.pending = append(r.pending, data[:toConsume]...)
+		r.chunkRemaining -= toConsume
+		r.bodyRead += toConsume
+		return toConsume, nil
+	case PARSING_TRAILERS:
+		if lineTooLong(data, r.limits.MaxHeaderLineBytes) {
+			return 0, ErrHeaderLineTooLong
+		}
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, err
+		}
+		if err := r.checkFieldSection(r.Trailers, n); err != nil {
+			return 0, err
 		}
-		if len(r.Body) == contentLength {
+		if done {
 			r.State = DONE
-			return len(data), nil
 		}
-
-		return len(data), nil
+		return n, nil
 	case DONE:
 		return 0, errors.New("trying to read data in a done state")
 	default:
@@ -205,9 +427,12 @@ func (r *Request) parseRequestLine(data []byte) (int, error) {
 	}
 
 	requestLine := string(data[:idx])
+	if strings.ContainsFunc(requestLine, isCTL) {
+		return 0, ErrMalformedRequestLine
+	}
 	parts := strings.Split(requestLine, " ")
 
-	if len(parts) != 3 {
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
 		return 0, ErrMalformedRequestLine
 	}
 
@@ -219,22 +444,124 @@ func (r *Request) parseRequestLine(data []byte) (int, error) {
 		return 0, ErrUnsupportedHttpVersion
 	}
 
-	if !r.RequestLine.ValidMethod() {
+	if !r.RequestLine.ValidMethod() && !(r.allowExtensionMethods && headers.IsToken(r.RequestLine.Method)) {
 		return 0, ErrUnsupportedHttpMethod
 	}
 
+	u, err := parseTarget(r.RequestLine.Method, r.RequestLine.RequestTarget)
+	if err != nil {
+		return 0, err
+	}
+	r.URL = u
+
 	consumed := idx + len(CRLF)
 	return consumed, nil
 }
 
+// getContentLength returns the declared body length. Repeated or
+// comma-separated values are accepted only if they are all identical.
 func (r Request) getContentLength() (int, error) {
-	contentLengthHeader := r.Headers.Get(CONTENT_LENGTH_HEADER)
-	if contentLengthHeader == "" {
-		return 0, nil
+	contentLength := -1
+	for _, value := range r.Headers.Values(CONTENT_LENGTH_HEADER) {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.Trim(part, " \t")
+			if part == "" || strings.Trim(part, "0123456789") != "" {
+				return 0, ErrInvalidContentLength
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, ErrInvalidContentLength
+			}
+			if contentLength != -1 && n != contentLength {
+				return 0, ErrConflictingContentLength
+			}
+			contentLength = n
+		}
 	}
-	contentLength, err := strconv.Atoi(contentLengthHeader)
-	if err != nil || contentLength < 0 {
-		return 0, ErrInvalidContentLength
+	if contentLength == -1 {
+		return 0, nil
 	}
 	return contentLength, nil
 }
+
+// isChunked validates Transfer-Encoding following RFC 9112 section 6.3:
+// it cannot be combined with Content-Length, chunked must be the final
+// coding and appear once, and no other coding is supported. HTTP/1.0 has
+// no transfer codings, so the field is refused there altogether.
+func (r Request) isChunked() (bool, error) {
+	if !r.Headers.Has(TRANSFER_ENCODING_HEADER) {
+		return false, nil
+	}
+	if r.RequestLine.HttpVersion == "1.0" {
+		return false, ErrInvalidTransferEncoding
+	}
+	if r.Headers.Has(CONTENT_LENGTH_HEADER) {
+		return false, ErrConflictingFraming
+	}
+
+	var codings []string
+	for _, value := range r.Headers.Values(TRANSFER_ENCODING_HEADER) {
+		for _, coding := range strings.Split(value, ",") {
+			if coding = strings.Trim(coding, " \t"); coding != "" {
+				codings = append(codings, strings.ToLower(coding))
+			}
+		}
+	}
+	if len(codings) == 0 || codings[len(codings)-1] != "chunked" {
+		return false, ErrInvalidTransferEncoding
+	}
+	for _, coding := range codings[:len(codings)-1] {
+		if coding == "chunked" {
+			return false, ErrInvalidTransferEncoding
+		}
+	}
+	if len(codings) > 1 {
+		return false, ErrUnsupportedTransferEncoding
+	}
+	return true, nil
+}
+
+// checkExpect refuses expectations other than 100-continue, the only one
+// defined by RFC 9110. HTTP/1.0 requests ignore the field.
+func (r Request) checkExpect() error {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return nil
+	}
+	for _, value := range r.Headers.Values(EXPECT_HEADER) {
+		for _, expectation := range strings.Split(value, ",") {
+			if !strings.EqualFold(strings.Trim(expectation, " \t"), "100-continue") {
+				return ErrUnsupportedExpectation
+			}
+		}
+	}
+	return nil
+}
+
+// parseChunkSize reads a chunk-size line, ignoring any chunk extensions.
+func parseChunkSize(data []byte) (int, int, error) {
+	idx := bytes.Index(data, []byte(CRLF))
+	if idx == -1 {
+		return 0, 0, nil
+	}
+
+	line := string(data[:idx])
+	// Extensions are skipped, but a bare CR or LF in them could end the
+	// line early for a front end, so control characters are rejected.
+	if strings.ContainsFunc(line, func(r rune) bool { return isCTL(r) && r != '\t' }) {
+		return 0, 0, ErrMalformedChunk
+	}
+	if semi := strings.IndexByte(line, ';'); semi != -1 {
+		line = line[:semi]
+	}
+	line = strings.TrimRight(line, " \t")
+
+	size, err := strconv.ParseUint(line, 16, 63)
+	if err != nil {
+		return 0, 0, ErrMalformedChunk
+	}
+	return idx + len(CRLF), int(size), nil
+}
+
+func isCTL(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
@@ -2,8 +2,10 @@ package request
 
 import (
 	"io"
+	"strings"
 	"testing"
 
+	"github.com/crunchydeer30/httpfromtcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -12,9 +14,11 @@ type chunkReader struct {
 	data            string
 	numBytesPerRead int
 	pos             int
+	reads           int
 }
 
 func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	cr.reads++
 	if cr.pos >= len(cr.data) {
 		return 0, io.EOF
 	}
@@ -92,7 +96,7 @@ func TestRequestLineParser(t *testing.T) {
 
 	// Test: Invalid method
 	reader = &chunkReader{
-		data:            "OPTIONS /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		data:            "BREW /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
 		numBytesPerRead: 32,
 	}
 	_, err = RequestFromReader(reader)
@@ -100,6 +104,42 @@ func TestRequestLineParser(t *testing.T) {
 	require.ErrorIs(t, err, ErrUnsupportedHttpMethod)
 }
 
+func TestMethods(t *testing.T) {
+	// Test: Standard methods are accepted
+	for _, method := range Methods {
+		target := "/"
+		switch method {
+		case "CONNECT":
+			target = "example.test:443"
+		case "OPTIONS":
+			target = "*"
+		}
+		r, err := RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: example.test\r\n\r\n"))
+		require.NoError(t, err, method)
+		assert.Equal(t, method, r.RequestLine.Method)
+	}
+
+	// Test: Methods are case-sensitive
+	_, err := RequestFromReader(strings.NewReader("get / HTTP/1.1\r\nHost: example.test\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedHttpMethod)
+
+	// Test: Extension methods need to be enabled
+	_, err = RequestFromReader(strings.NewReader("PROPFIND / HTTP/1.1\r\nHost: example.test\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedHttpMethod)
+
+	requestReader := NewReader(strings.NewReader("PROPFIND / HTTP/1.1\r\nHost: example.test\r\n\r\n"))
+	requestReader.AllowExtensionMethods = true
+	r, err := requestReader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "PROPFIND", r.RequestLine.Method)
+
+	// Test: Extension methods must be tokens
+	requestReader = NewReader(strings.NewReader("PROP@FIND / HTTP/1.1\r\nHost: example.test\r\n\r\n"))
+	requestReader.AllowExtensionMethods = true
+	_, err = requestReader.ReadRequest()
+	require.ErrorIs(t, err, ErrUnsupportedHttpMethod)
+}
+
 func TestHeaderParsing(t *testing.T) {
 	// Test: Standard Headers
 	reader := &chunkReader{
@@ -109,19 +149,19 @@ func TestHeaderParsing(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("Host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("User-Agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("Accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\n\r\n",
+		data:            "GET / HTTP/1.0\r\n\r\n",
 		numBytesPerRead: 128,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Headers))
+	assert.Equal(t, 0, r.Headers.Len())
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -133,23 +173,24 @@ func TestHeaderParsing(t *testing.T) {
 
 	// Test: Duplicate Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nX-Custom: value1\r\nX-Custom: value2\r\nX-Custom: value3\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nX-Custom: value1\r\nX-Custom: value2\r\nX-Custom: value3\r\n\r\n",
 		numBytesPerRead: 8,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "value1, value2, value3", r.Headers["x-custom"])
+	assert.Equal(t, "value1, value2, value3", r.Headers.Get("X-Custom"))
+	assert.Equal(t, []string{"value1", "value2", "value3"}, r.Headers.Values("x-custom"))
 
 	// Test: Case Insensitive Headers
 	reader = &chunkReader{
-		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nHOST: example.com\r\nhost: final.com\r\n\r\n",
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nVia: 1.1 a\r\nVIA: 1.1 b\r\nvia: 1.1 c\r\n\r\n",
 		numBytesPerRead: 8,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, example.com, final.com", r.Headers["host"])
+	assert.Equal(t, "1.1 a, 1.1 b, 1.1 c", r.Headers.Get("via"))
 
 	// Test: Missing End of Headers
 	reader = &chunkReader{
@@ -173,7 +214,9 @@ func TestBodyParsing(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
 
 	// Test: Empty Body, 0 reported content length
 	reader = &chunkReader{
@@ -186,8 +229,10 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
-	assert.Equal(t, 0, len(r.Body))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body))
+	assert.Equal(t, 0, len(body))
 
 	// Test: Empty Body, no reported content length
 	reader = &chunkReader{
@@ -199,8 +244,10 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
-	assert.Equal(t, 0, len(r.Body))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body))
+	assert.Equal(t, 0, len(body))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -211,7 +258,9 @@ func TestBodyParsing(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
 	require.Error(t, err)
 	require.ErrorIs(t, err, ErrIncompleteData)
 
@@ -226,6 +275,505 @@ func TestBodyParsing(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body)) // No Content-Length header means no body parsing
-	assert.Equal(t, 0, len(r.Body))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body)) // No Content-Length header means no body parsing
+	assert.Equal(t, 0, len(body))
+}
+
+func TestChunkedBodyParsing(t *testing.T) {
+	// Test: Standard chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Chunk extensions and hex sizes
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"a;name=value\r\n0123456789\r\n" +
+			"1 ;ext\r\n!\r\n" +
+			"0;last\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789!", string(body))
+
+	// Test: Trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"4\r\ndata\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(body))
+	assert.Equal(t, "abc123", r.Trailers.Get("X-Checksum"))
+	assert.Equal(t, "", r.Headers.Get("X-Checksum"))
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\nhello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 128,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+	require.ErrorIs(t, err, ErrMalformedChunk)
+
+	// Test: Chunk data longer than its size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 128,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+	require.ErrorIs(t, err, ErrMalformedChunk)
+
+	// Test: Missing last chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+	require.ErrorIs(t, err, ErrIncompleteData)
+}
+
+func TestStreamingBody(t *testing.T) {
+	// Test: Body is not read before the handler asks for it
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n" +
+			"hello world",
+		numBytesPerRead: 1,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Less(t, reader.pos, len(reader.data))
+
+	buf := make([]byte, 5)
+	n, err := io.ReadFull(r.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Less(t, reader.pos, len(reader.data))
+
+	rest, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, " world", string(rest))
+
+	// Test: Reading after Close
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Length: 4\r\n" +
+			"\r\n" +
+			"data",
+		numBytesPerRead: 64,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(buf)
+	require.ErrorIs(t, err, ErrBodyClosed)
+
+	// Test: Close gives up on large unread bodies
+	reader = &chunkReader{
+		data:            "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1048576\r\n\r\n" + strings.Repeat("x", 1<<20),
+		numBytesPerRead: 4096,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.ErrorIs(t, r.Body.Close(), ErrBodyNotDiscarded)
+	assert.LessOrEqual(t, reader.pos, MAX_DISCARD_BYTES+4096)
+
+	// Test: Large bodies are read straight into the caller's buffer
+	large := strings.Repeat("x", 1<<20)
+	for _, raw := range []string{
+		"POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1048576\r\n\r\n" + large,
+		"POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n100000\r\n" + large + "\r\n0\r\n\r\n",
+	} {
+		reader = &chunkReader{data: raw, numBytesPerRead: 1 << 20}
+		r, err = RequestFromReader(reader)
+		require.NoError(t, err)
+		reader.reads = 0
+		total := 0
+		big := make([]byte, 64<<10)
+		for {
+			n, err := r.Body.Read(big)
+			total += n
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+		}
+		assert.Equal(t, len(large), total)
+		assert.Less(t, reader.reads, 32)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	// Test: Two requests in a single stream, the first body left unread
+	reader := &chunkReader{
+		data: "POST /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"POST /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nworld\r\n0\r\n\r\n" +
+			"GET /third HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n",
+		numBytesPerRead: 256,
+	}
+	requestReader := NewReader(reader)
+
+	r, err := requestReader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+
+	r, err = requestReader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(body))
+
+	r, err = requestReader.ReadRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+
+	// Test: Clean close between requests
+	_, err = requestReader.ReadRequest()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestKeepAlive(t *testing.T) {
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 128,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nConnection: keep-alive, Close\r\n\r\n",
+		numBytesPerRead: 128,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// Test: HTTP/1.0 closes unless the client asks for keep-alive
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 without Host, with a body
+	r, err := RequestFromReader(strings.NewReader("POST /submit HTTP/1.0\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: HTTP/1.0 has no transfer codings
+	_, err = RequestFromReader(strings.NewReader("POST /submit HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	require.ErrorIs(t, err, ErrInvalidTransferEncoding)
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLineBytes: 32,
+		MaxHeaderLineBytes:  32,
+		MaxHeaderBytes:      64,
+		MaxHeaderCount:      3,
+		MaxBodyBytes:        8,
+	}
+	read := func(data string) (*Request, error) {
+		reader := NewReader(&chunkReader{data: data, numBytesPerRead: 4})
+		reader.Limits = limits
+		return reader.ReadRequest()
+	}
+
+	// Test: Request within every limit
+	r, err := read("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\n\r\n12345678")
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "12345678", string(body))
+
+	// Test: Request line too long, even without a CRLF in sight
+	_, err = read("GET /" + strings.Repeat("a", 64))
+	require.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Single header line too long
+	_, err = read("GET / HTTP/1.1\r\nX-Long: " + strings.Repeat("a", 64) + "\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeaderLineTooLong)
+
+	// Test: Header section too large
+	_, err = read("GET / HTTP/1.1\r\nX-A: " + strings.Repeat("a", 20) + "\r\nX-B: " + strings.Repeat("b", 20) + "\r\nX-C: " + strings.Repeat("c", 20) + "\r\n\r\n")
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Too many headers
+	_, err = read("GET / HTTP/1.1\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n")
+	require.ErrorIs(t, err, ErrTooManyHeaders)
+
+	// Test: Declared body too large
+	_, err = read("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 9\r\n\r\n123456789")
+	require.ErrorIs(t, err, ErrBodyTooLong)
+
+	// Test: Chunked body too large
+	r, err = read("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\n12345\r\n5\r\n67890\r\n0\r\n\r\n")
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrBodyTooLong)
+
+	// Test: Chunk size large enough to overflow the running total
+	r, err = read("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nx\r\n7fffffffffffffff\r\n" + strings.Repeat("x", 64))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrBodyTooLong)
+
+	// Test: Trailers count against the header limits
+	r, err = read("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n")
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrTooManyHeaders)
+}
+
+func TestParseErrors(t *testing.T) {
+	// Test: Unknown method suggests 501
+	_, err := RequestFromReader(&chunkReader{
+		data:            "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 128,
+	})
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.ErrorIs(t, err, ErrUnsupportedHttpMethod)
+	assert.Equal(t, 501, parseErr.Status)
+	assert.Equal(t, INITIALIZED, parseErr.State)
+	assert.Equal(t, 0, parseErr.Offset)
+
+	// Test: Unsupported version suggests 505
+	_, err = RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 128,
+	})
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 505, parseErr.Status)
+
+	// Test: Malformed header records where it failed
+	_, err = RequestFromReader(&chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nBroken header\r\n\r\n",
+		numBytesPerRead: 3,
+	})
+	require.ErrorAs(t, err, &parseErr)
+	assert.ErrorIs(t, err, headers.ErrMalformedHeader)
+	assert.Equal(t, 400, parseErr.Status)
+	assert.Equal(t, PARSING_HEADERS, parseErr.State)
+	assert.Equal(t, len("GET / HTTP/1.1\r\nHost: localhost\r\n"), parseErr.Offset)
+	assert.Equal(t, "malformed header at byte 33 while parsing headers", parseErr.Error())
+
+	// Test: Unknown expectation suggests 417
+	_, err = RequestFromReader(&chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue, teapot\r\n\r\n",
+		numBytesPerRead: 128,
+	})
+	require.ErrorAs(t, err, &parseErr)
+	assert.ErrorIs(t, err, ErrUnsupportedExpectation)
+	assert.Equal(t, 417, parseErr.Status)
+}
+
+func TestExpectContinue(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-Continue\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+
+	// Test: HTTP/1.0 ignores expectations
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nExpect: teapot\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	assert.False(t, r.ExpectsContinue())
+}
+
+func TestHostValidation(t *testing.T) {
+	read := func(data string) (*Request, error) {
+		return RequestFromReader(strings.NewReader(data))
+	}
+
+	valid := []string{"example.test", "EXAMPLE.test:8080", "127.0.0.1", "[::1]:42069", "xn--bcher-kva.test", "my%2Dhost", ""}
+	for _, host := range valid {
+		r, err := read("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n")
+		require.NoError(t, err, host)
+		assert.Equal(t, host, r.Host())
+	}
+
+	invalid := []string{"exa mple.test", "user@example.test", "example.test:http", "[::1", "[::1]x", "[zz]", ":80", "example.test/path"}
+	for _, host := range invalid {
+		_, err := read("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n")
+		require.ErrorIs(t, err, ErrInvalidHost, host)
+	}
+
+	// Test: HTTP/1.1 requires a Host
+	_, err := read("GET / HTTP/1.1\r\n\r\n")
+	require.ErrorIs(t, err, ErrMissingHost)
+
+	// Test: Only one Host is allowed
+	_, err = read("GET / HTTP/1.1\r\nHost: a.test\r\nhost: b.test\r\n\r\n")
+	require.ErrorIs(t, err, ErrDuplicateHost)
+
+	// Test: HTTP/1.0 may leave it out
+	r, err := read("GET / HTTP/1.0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Empty(t, r.Host())
+
+	// Test: The absolute-form authority wins over the Host header
+	r, err = read("GET http://target.test/x HTTP/1.1\r\nHost: header.test\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "target.test", r.Host())
+}
+
+func TestRequestTargetParsing(t *testing.T) {
+	parse := func(method, target string) (*Request, error) {
+		return RequestFromReader(&chunkReader{
+			data:            method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			numBytesPerRead: 16,
+		})
+	}
+
+	// Test: Origin form with query
+	r, err := parse("GET", "/search/a%20b?q=go+lang&tag=x&tag=y%26z&empty=")
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, r.URL.Form)
+	assert.Equal(t, "/search/a b", r.URL.Path)
+	assert.Equal(t, "/search/a%20b", r.URL.RawPath)
+	assert.Equal(t, "q=go+lang&tag=x&tag=y%26z&empty=", r.URL.RawQuery)
+	query := r.URL.Query()
+	assert.Equal(t, "go lang", query.Get("q"))
+	assert.Equal(t, []string{"x", "y&z"}, query["tag"])
+	assert.Equal(t, []string{""}, query["empty"])
+	assert.Equal(t, "", query.Get("missing"))
+
+	// Test: Dot segments are removed, including encoded ones
+	r, err = parse("GET", "/a/b/../c/./d")
+	require.NoError(t, err)
+	assert.Equal(t, "/a/c/d", r.URL.Path)
+	r, err = parse("GET", "/static/%2e%2e/%2E%2E/etc/passwd")
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/passwd", r.URL.Path)
+	r, err = parse("GET", "/a/b/..")
+	require.NoError(t, err)
+	assert.Equal(t, "/a/", r.URL.Path)
+
+	// Test: Encoded slashes stay inside their segment
+	r, err = parse("GET", "/a%2Fb/../c")
+	require.NoError(t, err)
+	assert.Equal(t, "/c", r.URL.Path)
+	assert.Equal(t, []string{"c"}, r.URL.Segments())
+	r, err = parse("GET", "/files/a%2fb/%2e%2e%2Fsecret")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/a/b/../secret", r.URL.Path)
+	assert.Equal(t, "/files/a%2fb/..%2Fsecret", r.URL.EscapedPath)
+	assert.Equal(t, []string{"files", "a/b", "../secret"}, r.URL.Segments())
+
+	// Test: Absolute form
+	r, err = parse("GET", "http://example.test:8080/path?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, r.URL.Form)
+	assert.Equal(t, "http", r.URL.Scheme)
+	assert.Equal(t, "example.test:8080", r.URL.Host)
+	assert.Equal(t, "/path", r.URL.Path)
+	assert.Equal(t, "x=1", r.URL.RawQuery)
+
+	r, err = parse("GET", "https://example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "/", r.URL.Path)
+
+	// Test: Malformed targets
+	for _, target := range []string{"/bad%zz", "/bad%4", "/ok?q=%g1", "/frag#ment", "relative/path", "http://", "http://user@host/", "*"} {
+		_, err = parse("GET", target)
+		require.ErrorIs(t, err, ErrMalformedRequestTarget, target)
+	}
+}
+
+func TestAuthorityAndAsteriskForms(t *testing.T) {
+	u, err := parseTarget("CONNECT", "example.test:443")
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, u.Form)
+	assert.Equal(t, "example.test:443", u.Host)
+
+	_, err = parseTarget("CONNECT", "example.test")
+	require.ErrorIs(t, err, ErrMalformedRequestTarget)
+
+	u, err = parseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, u.Form)
+	assert.Equal(t, "*", u.Path)
 }
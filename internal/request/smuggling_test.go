@@ -0,0 +1,193 @@
+package request
+
+import (
+	"testing"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAll parses a request and its body the way a server would before it
+// could hand the rest of the stream to the next request.
+func readAll(data string) (*Request, []byte, error) {
+	r, err := RequestFromReader(&chunkReader{data: data, numBytesPerRead: 7})
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := r.ReadBody()
+	return r, body, err
+}
+
+func TestSmugglingPayloadsAreRejected(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{
+			name:    "CL.TE",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 13\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nSMUGGLED",
+			wantErr: ErrConflictingFraming,
+		},
+		{
+			name:    "TE.CL",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n",
+			wantErr: ErrConflictingFraming,
+		},
+		{
+			name:    "differing duplicate Content-Length",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+			wantErr: ErrConflictingContentLength,
+		},
+		{
+			name:    "differing Content-Length list",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 6\r\n\r\nhello!",
+			wantErr: ErrConflictingContentLength,
+		},
+		{
+			name:    "signed Content-Length",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +5\r\n\r\nhello",
+			wantErr: ErrInvalidContentLength,
+		},
+		{
+			name:    "hex Content-Length",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x5\r\n\r\nhello",
+			wantErr: ErrInvalidContentLength,
+		},
+		{
+			name:    "Content-Length with inner space",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1 0\r\n\r\nhello",
+			wantErr: ErrInvalidContentLength,
+		},
+		{
+			name:    "overflowing Content-Length",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 99999999999999999999999\r\n\r\nhello",
+			wantErr: ErrInvalidContentLength,
+		},
+		{
+			name:    "obfuscated Transfer-Encoding value",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n",
+			wantErr: ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "chunked not last",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n",
+			wantErr: ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "chunked twice",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			wantErr: ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "unsupported coding before chunked",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+			wantErr: ErrUnsupportedTransferEncoding,
+		},
+		{
+			name:    "whitespace before colon",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n",
+			wantErr: headers.ErrMalformedHeader,
+		},
+		{
+			name:    "tab before colon",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nContent-Length\t: 5\r\n\r\nhello",
+			wantErr: headers.ErrMalformedHeader,
+		},
+		{
+			name:    "obs-fold",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nX-Padding: x\r\n Transfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			wantErr: headers.ErrMalformedHeader,
+		},
+		{
+			name:    "whitespace after request line",
+			data:    "POST / HTTP/1.1\r\n\tTransfer-Encoding: chunked\r\nHost: a\r\n\r\n0\r\n\r\n",
+			wantErr: headers.ErrMalformedHeader,
+		},
+		{
+			name:    "bare LF in header value",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nX-Padding: x\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			wantErr: headers.ErrMalformedHeader,
+		},
+		{
+			name:    "bare CR in header value",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nX-Padding: x\rTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			wantErr: headers.ErrMalformedHeader,
+		},
+		{
+			name:    "NUL in header value",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nX-Padding: x\x00y\r\n\r\n",
+			wantErr: headers.ErrMalformedHeader,
+		},
+		{
+			name:    "non-ASCII header name",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encodíng: chunked\r\n\r\n0\r\n\r\n",
+			wantErr: headers.ErrMalformedHeader,
+		},
+		{
+			name:    "bare LF in request line",
+			data:    "POST / HTTP/1.1\nHost: a\r\n\r\n",
+			wantErr: ErrMalformedRequestLine,
+		},
+		{
+			name:    "tab in request line",
+			data:    "POST\t/ HTTP/1.1\r\nHost: a\r\n\r\n",
+			wantErr: ErrMalformedRequestLine,
+		},
+		{
+			name:    "missing HTTP prefix",
+			data:    "POST / 1.1\r\nHost: a\r\n\r\n",
+			wantErr: ErrMalformedRequestLine,
+		},
+		{
+			name:    "negative chunk size",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n-1\r\nx\r\n0\r\n\r\n",
+			wantErr: ErrMalformedChunk,
+		},
+		{
+			name:    "prefixed chunk size",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0x5\r\nhello\r\n0\r\n\r\n",
+			wantErr: ErrMalformedChunk,
+		},
+		{
+			name:    "overflowing chunk size",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nffffffffffffffffff\r\nx\r\n0\r\n\r\n",
+			wantErr: ErrMalformedChunk,
+		},
+		{
+			name:    "bare LF after chunk size",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n",
+			wantErr: ErrMalformedChunk,
+		},
+		{
+			name:    "bare LF in chunk extension",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3;a\nb\r\nabc\r\n0\r\n\r\n",
+			wantErr: ErrMalformedChunk,
+		},
+		{
+			name:    "bare CR in chunk extension",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3;a\rb\r\nabc\r\n0\r\n\r\n",
+			wantErr: ErrMalformedChunk,
+		},
+		{
+			name:    "chunk data without CRLF",
+			data:    "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello0\r\n\r\n",
+			wantErr: ErrMalformedChunk,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := readAll(tc.data)
+			require.Error(t, err)
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestIdenticalContentLengthsAreAccepted(t *testing.T) {
+	_, body, err := readAll("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5, 5\r\n\r\nhello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
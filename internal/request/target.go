@@ -0,0 +1,264 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+)
+
+var ErrMalformedRequestTarget = fmt.Errorf("malformed request target")
+
+// TargetForm is the shape of a request target, see RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	OriginForm TargetForm = iota
+	AbsoluteForm
+	AuthorityForm
+	AsteriskForm
+)
+
+// URL is the parsed request target.
+type URL struct {
+	Form TargetForm
+	// Scheme is only set for the absolute form.
+	Scheme string
+	// Host is set for the absolute and authority forms.
+	Host string
+	// Path is percent-decoded with dot segments removed. It is empty for
+	// the authority form and "*" for the asterisk form. An encoded slash
+	// is decoded like any other byte, so use Segments to split the path.
+	Path string
+	// EscapedPath is the path with dot segments removed but reserved
+	// characters still percent-encoded.
+	EscapedPath string
+	// RawPath is the path exactly as it was sent.
+	RawPath  string
+	RawQuery string
+}
+
+// Segments splits the path on the slashes sent as such and decodes each
+// segment, so an encoded slash stays inside its segment.
+func (u *URL) Segments() []string {
+	segments := strings.Split(strings.TrimPrefix(u.EscapedPath, "/"), "/")
+	for i, segment := range segments {
+		// EscapedPath was validated when the target was parsed.
+		segments[i], _ = unescape(segment)
+	}
+	return segments
+}
+
+// Values maps query parameter names to their values in order.
+type Values map[string][]string
+
+// Get returns the first value of key, or "".
+func (v Values) Get(key string) string {
+	if values := v[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Query parses RawQuery. Pairs are separated by "&" and "+" decodes to a
+// space.
+func (u *URL) Query() Values {
+	values := Values{}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(pair, "=")
+		key, err := unescape(strings.ReplaceAll(key, "+", " "))
+		if err != nil {
+			continue
+		}
+		value, err = unescape(strings.ReplaceAll(value, "+", " "))
+		if err != nil {
+			continue
+		}
+		values[key] = append(values[key], value)
+	}
+	return values
+}
+
+func parseTarget(method, target string) (*URL, error) {
+	if strings.Contains(target, "#") {
+		return nil, ErrMalformedRequestTarget
+	}
+
+	switch {
+	case strings.HasPrefix(target, "/"):
+		return parseOriginForm(target)
+	case target == "*":
+		if method != "OPTIONS" {
+			return nil, ErrMalformedRequestTarget
+		}
+		return &URL{Form: AsteriskForm, Path: "*", EscapedPath: "*", RawPath: "*"}, nil
+	case method == "CONNECT":
+		host, port, found := strings.Cut(target, ":")
+		if !found || host == "" || port == "" || strings.ContainsAny(target, "/?@") {
+			return nil, ErrMalformedRequestTarget
+		}
+		return &URL{Form: AuthorityForm, Host: target}, nil
+	default:
+		return parseAbsoluteForm(target)
+	}
+}
+
+// parseOriginForm removes dot segments before decoding the path, so that
+// an encoded slash such as in "/a%2Fb/../c" cannot take part in it.
+func parseOriginForm(target string) (*URL, error) {
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	escapedPath, err := unescapeUnreserved(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := unescape(rawQuery); err != nil {
+		return nil, err
+	}
+	escapedPath = removeDotSegments(escapedPath)
+	path, err := unescape(escapedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &URL{
+		Form:        OriginForm,
+		Path:        path,
+		EscapedPath: escapedPath,
+		RawPath:     rawPath,
+		RawQuery:    rawQuery,
+	}, nil
+}
+
+func parseAbsoluteForm(target string) (*URL, error) {
+	scheme, rest, found := strings.Cut(target, "://")
+	if !found || !isScheme(scheme) {
+		return nil, ErrMalformedRequestTarget
+	}
+
+	end := strings.IndexAny(rest, "/?")
+	if end == -1 {
+		end = len(rest)
+	}
+	host := rest[:end]
+	if host == "" || strings.Contains(host, "@") {
+		return nil, ErrMalformedRequestTarget
+	}
+
+	u, err := parseOriginForm("/" + strings.TrimPrefix(rest[end:], "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Form = AbsoluteForm
+	u.Scheme = strings.ToLower(scheme)
+	u.Host = host
+	return u, nil
+}
+
+func isScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// unescape decodes percent-encoded octets and rejects malformed escapes.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return "", ErrMalformedRequestTarget
+		}
+		b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// unescapeUnreserved decodes the escapes of characters that mean the same
+// encoded or not, such as "%2E", and keeps the others, such as "%2F".
+func unescapeUnreserved(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return "", ErrMalformedRequestTarget
+		}
+		if c := unhex(s[i+1])<<4 | unhex(s[i+2]); isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(s[i : i+3])
+		}
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// isUnreserved reports whether c is an RFC 3986 unreserved character.
+func isUnreserved(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// removeDotSegments implements RFC 3986 section 5.2.4 for absolute paths.
+func removeDotSegments(path string) string {
+	segments := strings.Split(path, "/")[1:]
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, segment)
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
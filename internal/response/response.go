@@ -3,7 +3,8 @@ package response
 import (
 	"bytes"
 	"fmt"
-	"net"
+	"io"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -20,105 +21,451 @@ const (
 	WaitingForBody       ResponseWriterState = "WaitingForBody"
 )
 
+// BUFFER_SIZE is how much of the body is held back before the response is
+// committed with chunked encoding instead of a Content-Length.
+const BUFFER_SIZE = 4096
+
 type ResponseWriter struct {
-	conn           net.Conn
-	Headers        headers.Headers
+	conn           io.Writer
+	Headers        *headers.Headers
 	statusWritten  bool
 	headersWritten bool
 	bodyBuffer     *bytes.Buffer
+	statusCode     StatusCode
+	bytesWritten   int
+	chunked        bool
+	chunkedDone    bool
+	trailerNames   []string
+	trailers       *headers.Headers
+	noBody         bool
+	version        string
+}
+
+var ErrBodyDone = fmt.Errorf("response body already terminated")
+
+// ErrContentLength is returned when the body written does not match the
+// Content-Length set by the handler. The connection cannot be reused after
+// a short body, since the client is still waiting for the missing bytes.
+var ErrContentLength = fmt.Errorf("response body does not match Content-Length")
+
+// forbiddenTrailers are fields a sender must not put in a trailer section
+// because they control framing, routing or the meaning of the message.
+var forbiddenTrailers = []string{
+	"content-length", "transfer-encoding", "trailer", "host", "connection",
+	"content-type", "content-encoding", "content-range", "authorization",
+	"set-cookie", "cache-control", "expect", "te",
 }
 
-func NewResponseWriter(conn net.Conn) *ResponseWriter {
+func NewResponseWriter(conn io.Writer) *ResponseWriter {
 	return &ResponseWriter{
 		bodyBuffer:     bytes.NewBuffer([]byte{}),
 		conn:           conn,
 		statusWritten:  false,
 		headersWritten: false,
 		Headers:        headers.NewHeaders(),
+		trailers:       headers.NewHeaders(),
+		version:        "1.1",
 	}
 }
 
+// SetVersion sets the HTTP version written in status lines, "1.1" unless
+// changed. HTTP/1.0 clients do not understand chunked encoding, so for them
+// the body is buffered until Finalize unless the handler sets a
+// Content-Length, trailers are dropped and no interim responses are sent.
+func (w *ResponseWriter) SetVersion(version string) {
+	w.version = version
+}
+
+// canChunk reports whether the client understands chunked encoding.
+func (w *ResponseWriter) canChunk() bool {
+	return w.version != "1.0"
+}
+
+// SuppressBody makes the writer discard the body while still announcing
+// its length, as the response to a HEAD request must. Handlers can write
+// the same body they would for GET.
+func (w *ResponseWriter) SuppressBody() {
+	w.noBody = true
+}
+
+// WriteStatusLine sets the final status. The status line is sent together
+// with the headers, so interim responses such as 100 Continue can still be
+// sent after it, for instance when the handler reads the body only after
+// choosing the status.
 func (w *ResponseWriter) WriteStatusLine(statusCode StatusCode) error {
 	if w.statusWritten {
 		return fmt.Errorf("status line already written")
 	}
-
-	_, err := w.conn.Write([]byte("HTTP/1.1 " + statusCode.String() + " " + statusCode.StatusText() + "\r\n"))
-	if err != nil {
-		return err
+	if statusCode.IsInformational() {
+		return fmt.Errorf("use WriteInformational for 1xx status %d", statusCode)
 	}
 
 	w.statusWritten = true
+	w.statusCode = statusCode
+	if !w.bodyAllowed() {
+		w.noBody = true
+	}
 	return nil
 }
 
+// WriteInformational sends an interim 1xx response, such as 100 Continue or
+// 103 Early Hints, ahead of the final one. It can be called several times
+// but only until the final status line is sent with the headers. It does
+// nothing for HTTP/1.0 clients.
+func (w *ResponseWriter) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if w.headersWritten {
+		return fmt.Errorf("status line already written")
+	}
+	if !statusCode.IsInformational() || statusCode == StatusSwitchingProtocols {
+		return fmt.Errorf("status %d is not an informational status", statusCode)
+	}
+	if w.version == "1.0" {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString(w.statusLine(statusCode))
+	for k, v := range h.All() {
+		b.WriteString(k + ": " + v + CRLF)
+	}
+	b.WriteString(CRLF)
+	_, err := w.conn.Write([]byte(b.String()))
+	return err
+}
+
+func (w *ResponseWriter) statusLine(statusCode StatusCode) string {
+	return "HTTP/" + w.version + " " + statusCode.String() + " " + statusCode.StatusText() + CRLF
+}
+
+// StatusCode returns the status written so far, or StatusOK if the handler
+// has not written one and the default will be used.
+func (w *ResponseWriter) StatusCode() StatusCode {
+	if !w.statusWritten {
+		return StatusOK
+	}
+	return w.statusCode
+}
+
+// Committed reports whether the final status line and headers have been
+// sent, after which the response can no longer be replaced.
+func (w *ResponseWriter) Committed() bool {
+	return w.headersWritten
+}
+
+// bodyAllowed reports whether the status permits a body. 1xx, 204 and 304
+// responses end with the headers, so whatever the handler writes for them
+// is dropped.
+func (w *ResponseWriter) bodyAllowed() bool {
+	code := w.StatusCode()
+	return !code.IsInformational() && code != StatusNoContent && code != StatusNotModified
+}
+
+// declaredLength returns the Content-Length set by the handler, or -1 if
+// the body is chunked or its length was left to the writer.
+func (w *ResponseWriter) declaredLength() int {
+	if w.chunked || strings.EqualFold(w.Headers.Get("Transfer-Encoding"), "chunked") {
+		return -1
+	}
+	if !w.headersWritten && len(w.trailerNames) > 0 && w.canChunk() {
+		return -1
+	}
+	n, err := strconv.Atoi(w.Headers.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// BytesWritten returns the number of body bytes written by the handler,
+// whether they are still buffered or already sent as chunks.
+func (w *ResponseWriter) BytesWritten() int {
+	return w.bytesWritten
+}
+
+// WriteHeaders sends the status line and headers. A handler calling it
+// directly without setting a Content-Length gets chunked encoding, or for
+// HTTP/1.0 clients a closed connection, to mark the end of the body.
 func (w *ResponseWriter) WriteHeaders() error {
 	if w.headersWritten {
 		return fmt.Errorf("headers already sent")
 	}
+	w.setFraming()
 
-	for k, v := range w.Headers {
-		values := strings.Split(v, ",")
-		for _, val := range values {
-			_, err := w.conn.Write([]byte(k + ": " + val + "\r\n"))
-			if err != nil {
-				return err
-			}
-		}
+	var b strings.Builder
+	b.WriteString(w.statusLine(w.StatusCode()))
+	for k, v := range w.Headers.All() {
+		b.WriteString(k + ": " + v + CRLF)
 	}
-	_, err := w.conn.Write([]byte("\r\n"))
+	b.WriteString(CRLF)
+	_, err := w.conn.Write([]byte(b.String()))
 	if err != nil {
 		return err
 	}
 
 	w.headersWritten = true
+	w.chunked = strings.EqualFold(w.Headers.Get("Transfer-Encoding"), "chunked")
 	return nil
 }
 
-func (w *ResponseWriter) Write(p []byte) error {
+// Write implements io.Writer. The body is buffered until it outgrows
+// BUFFER_SIZE or Flush is called, after which it is streamed out.
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	if n := w.declaredLength(); n >= 0 && w.bytesWritten+len(p) > n && w.bodyAllowed() {
+		return 0, ErrContentLength
+	}
+	if w.headersWritten {
+		return w.writeBody(p)
+	}
+	if w.noBody {
+		w.bytesWritten += len(p)
+		return len(p), nil
+	}
+
 	w.bodyBuffer.Write(p)
-	w.Headers.Replace("Content-Length", strconv.Itoa(w.bodyBuffer.Len()))
+	w.bytesWritten += len(p)
+	if w.bodyBuffer.Len() > BUFFER_SIZE {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the status line, headers and everything buffered so far. If
+// the handler has not set a Content-Length, the rest of the body is sent
+// with chunked encoding. When the body is suppressed nothing is sent until
+// Finalize, so the Content-Length can still be computed. The same applies
+// to HTTP/1.0 clients unless a Content-Length was set.
+func (w *ResponseWriter) Flush() error {
+	if !w.headersWritten {
+		hasLength := w.Headers.Get("Content-Length") != ""
+		if w.noBody || (!w.canChunk() && !hasLength) {
+			return nil
+		}
+		if w.canChunk() && (!hasLength || len(w.trailerNames) > 0) {
+			w.Headers.Del("Content-Length")
+			w.Headers.Set("Transfer-Encoding", "chunked")
+		}
+		if err := w.commit(); err != nil {
+			return err
+		}
+	}
+
+	if w.bodyBuffer.Len() == 0 {
+		return nil
+	}
+	err := w.sendBody(w.bodyBuffer.Bytes())
+	w.bodyBuffer.Reset()
+	return err
+}
+
+// DeclareTrailer announces trailer fields in the Trailer header. It must be
+// called before the headers are sent and forces chunked encoding so the
+// trailers can follow the last chunk.
+func (w *ResponseWriter) DeclareTrailer(names ...string) error {
+	if w.headersWritten {
+		return fmt.Errorf("headers already sent")
+	}
+	for _, name := range names {
+		if slices.Contains(forbiddenTrailers, strings.ToLower(name)) {
+			return fmt.Errorf("%s is not allowed in trailers", name)
+		}
+		if !slices.ContainsFunc(w.trailerNames, func(n string) bool { return strings.EqualFold(n, name) }) {
+			w.trailerNames = append(w.trailerNames, name)
+		}
+	}
+	w.Headers.Set("Trailer", strings.Join(w.trailerNames, ", "))
 	return nil
 }
 
-func (w *ResponseWriter) WriteTrailers(h headers.Headers) {
-	for k, v := range h {
-		w.conn.Write([]byte(k + ": " + v + "\r\n"))
+// SetTrailer sets the value of a trailer previously declared with
+// DeclareTrailer. Trailers are sent when the body is terminated.
+func (w *ResponseWriter) SetTrailer(name, value string) error {
+	if w.chunkedDone {
+		return ErrBodyDone
+	}
+	if !slices.ContainsFunc(w.trailerNames, func(n string) bool { return strings.EqualFold(n, name) }) {
+		return fmt.Errorf("trailer %s was not declared", name)
 	}
+	w.trailers.Set(name, value)
+	return nil
 }
 
+// Finalize completes the response after the handler returns. A body that
+// stayed in the buffer is sent with a Content-Length, and a chunked body is
+// terminated with the last chunk.
 func (w *ResponseWriter) Finalize() error {
-	w.WriteStatusLine(200)
-	w.SetDefaultHeaders(w.bodyBuffer.Len())
-	w.WriteHeaders()
-	_, err := w.conn.Write(w.bodyBuffer.Bytes())
-	return err
+	if !w.headersWritten {
+		if !w.canChunk() {
+			w.Headers.Del("Transfer-Encoding")
+		} else if len(w.trailerNames) > 0 {
+			w.Headers.Set("Transfer-Encoding", "chunked")
+		}
+		contentLen := w.bodyBuffer.Len()
+		if w.noBody {
+			contentLen = w.bytesWritten
+		}
+		w.SetDefaultHeaders(contentLen)
+		if err := w.commit(); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if w.chunked && !w.chunkedDone {
+		_, err := w.WriteChunkedBodyDone()
+		return err
+	}
+	if n := w.declaredLength(); n >= 0 && w.bytesWritten < n && !w.noBody {
+		return ErrContentLength
+	}
+	return nil
 }
 
+// SetDefaultHeaders fills in the framing and Content-Type headers the
+// handler left out. Responses that cannot have a body get neither, and a
+// 204 must not carry a Content-Length at all.
 func (w *ResponseWriter) SetDefaultHeaders(contentLen int) {
-	if w.Headers.Get("Content-Length") == "" {
-		w.Headers.Set("Content-Length", "0")
+	if !w.bodyAllowed() {
+		w.Headers.Del("Transfer-Encoding")
+		w.Headers.Del("Trailer")
+		if w.StatusCode() == StatusNoContent {
+			w.Headers.Del("Content-Length")
+		}
+		return
 	}
-	if w.Headers.Get("Connection") == "" {
-		w.Headers.Set("Connection", "close")
+	if w.Headers.Get("Content-Length") == "" {
+		w.Headers.Set("Content-Length", strconv.Itoa(contentLen))
 	}
-	if w.Headers.Get("Transfer-Encoding") == "chunked" {
-		w.Headers.Delete("Content-Length")
-		w.Headers.Delete("Connection")
+	if strings.EqualFold(w.Headers.Get("Transfer-Encoding"), "chunked") {
+		w.Headers.Del("Content-Length")
 	}
 	if w.Headers.Get("Content-Type") == "" {
 		w.Headers.Set("Content-Type", "text/plain")
 	}
 }
 
+// WriteChunkedBody sends p as a chunk of its own, committing the response
+// with chunked encoding first if the headers are not out yet. Empty writes
+// are ignored, since an empty chunk would end the body. Where chunked
+// encoding cannot be used p is written like any other body data.
 func (w *ResponseWriter) WriteChunkedBody(p []byte) (int, error) {
-	n, _ := w.conn.Write([]byte(fmt.Sprintf("%x\r\n%s\r\n", len(p), p)))
+	if w.chunkedDone {
+		return 0, ErrBodyDone
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !w.headersWritten && w.canChunk() && !w.noBody {
+		w.Headers.Del("Content-Length")
+		w.Headers.Set("Transfer-Encoding", "chunked")
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	if !w.chunked {
+		return w.Write(p)
+	}
 
-	return n, nil
+	if _, err := w.writeChunk(p); err != nil {
+		return 0, err
+	}
+	w.bytesWritten += len(p)
+	return len(p), nil
 }
 
+// WriteChunkedBodyDone writes the last chunk followed by the declared
+// trailers that have a value and the final empty line.
 func (w *ResponseWriter) WriteChunkedBodyDone() (int, error) {
-	done := []byte("0\r\n")
-	w.conn.Write(done)
-	return len(done), nil
+	if w.chunkedDone {
+		return 0, ErrBodyDone
+	}
+
+	if w.noBody {
+		w.chunkedDone = true
+		return 0, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("0" + CRLF)
+	for _, name := range w.trailerNames {
+		if value := w.trailers.Get(name); value != "" {
+			b.WriteString(name + ": " + value + CRLF)
+		}
+	}
+	b.WriteString(CRLF)
+
+	n, err := w.conn.Write([]byte(b.String()))
+	w.chunkedDone = true
+	return n, err
+}
+
+// setFraming fills in what the client needs to find the end of the body
+// when neither Flush nor Finalize has chosen the framing, along with the
+// default Content-Type.
+func (w *ResponseWriter) setFraming() {
+	if !w.canChunk() {
+		w.Headers.Del("Transfer-Encoding")
+		w.Headers.Del("Trailer")
+	}
+	if !w.bodyAllowed() {
+		return
+	}
+	if w.Headers.Get("Content-Type") == "" {
+		w.Headers.Set("Content-Type", "text/plain")
+	}
+	if w.Headers.Get("Content-Length") != "" || strings.EqualFold(w.Headers.Get("Transfer-Encoding"), "chunked") {
+		return
+	}
+	if w.canChunk() {
+		w.Headers.Set("Transfer-Encoding", "chunked")
+	} else {
+		w.Headers.Set("Connection", "close")
+	}
+}
+
+func (w *ResponseWriter) commit() error {
+	if !w.statusWritten {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return err
+		}
+	}
+	return w.WriteHeaders()
+}
+
+// writeBody writes handler data once the headers are out.
+func (w *ResponseWriter) writeBody(p []byte) (int, error) {
+	if w.chunkedDone {
+		return 0, ErrBodyDone
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.sendBody(p); err != nil {
+		return 0, err
+	}
+	w.bytesWritten += len(p)
+	return len(p), nil
+}
+
+// sendBody puts p on the wire, framed as a chunk if the response is chunked.
+func (w *ResponseWriter) sendBody(p []byte) error {
+	if w.noBody {
+		return nil
+	}
+	if !w.chunked {
+		_, err := w.conn.Write(p)
+		return err
+	}
+	_, err := w.writeChunk(p)
+	return err
+}
+
+func (w *ResponseWriter) writeChunk(p []byte) (int, error) {
+	if w.noBody {
+		return 0, nil
+	}
+	return w.conn.Write([]byte(fmt.Sprintf("%x\r\n%s\r\n", len(p), p)))
 }
@@ -0,0 +1,340 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Not Found", StatusNotFound.StatusText())
+	assert.Equal(t, "Content Too Large", StatusContentTooLarge.StatusText())
+	assert.Equal(t, "HTTP Version Not Supported", StatusHTTPVersionNotSupported.StatusText())
+	assert.Equal(t, "", StatusCode(599).StatusText())
+
+	assert.True(t, StatusEarlyHints.IsInformational())
+	assert.True(t, StatusNoContent.IsSuccess())
+	assert.True(t, StatusPermanentRedirect.IsRedirect())
+	assert.True(t, StatusTooManyRequests.IsClientError())
+	assert.True(t, StatusBadGateway.IsServerError())
+	assert.False(t, StatusOK.IsClientError())
+}
+
+func TestWriteStatusLine(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	assert.Empty(t, buf.String())
+	assert.Equal(t, StatusNotFound, w.StatusCode())
+	assert.False(t, w.Committed())
+
+	// Test: Status line goes out with the headers
+	require.NoError(t, w.WriteHeaders())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, w.Committed())
+
+	// Test: Status line can only be written once
+	require.Error(t, w.WriteStatusLine(StatusOK))
+
+	// Test: 1xx codes are not final statuses
+	w = NewResponseWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteStatusLine(StatusContinue))
+}
+
+func TestWriteInformational(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	hints := headers.NewHeaders()
+	hints.Set("Link", "</style.css>; rel=preload; as=style")
+	require.NoError(t, w.WriteInformational(StatusEarlyHints, hints))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+
+	// Test: Still allowed until the final status is sent
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	require.NoError(t, w.WriteHeaders())
+
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 100 Continue\r\n\r\n"+
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 100 Continue\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\n"))
+
+	// Test: Not allowed after the final status
+	require.Error(t, w.WriteInformational(StatusContinue, nil))
+
+	// Test: Only 1xx codes other than 101
+	w = NewResponseWriter(&bytes.Buffer{})
+	require.Error(t, w.WriteInformational(StatusOK, nil))
+	require.Error(t, w.WriteInformational(StatusSwitchingProtocols, nil))
+}
+
+func readResponse(t *testing.T, buf *bytes.Buffer) (*http.Response, string) {
+	t.Helper()
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestAutomaticFraming(t *testing.T) {
+	// Test: Small body gets a Content-Length
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	fmt.Fprintf(w, "hello %s", "world")
+	require.NoError(t, w.Finalize())
+	res, body := readResponse(t, buf)
+	assert.Equal(t, "hello world", body)
+	assert.Equal(t, int64(11), res.ContentLength)
+	assert.Empty(t, res.TransferEncoding)
+	assert.Equal(t, 11, w.BytesWritten())
+
+	// Test: Body larger than the buffer switches to chunked
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	large := strings.Repeat("x", BUFFER_SIZE*3)
+	n, err := io.Copy(w, strings.NewReader(large))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(large)), n)
+	require.NoError(t, w.Finalize())
+	res, body = readResponse(t, buf)
+	assert.Equal(t, large, body)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, len(large), w.BytesWritten())
+
+	// Test: Flush before the handler returns switches to chunked
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.WriteStatusLine(StatusCreated)
+	w.Write([]byte("first "))
+	require.NoError(t, w.Flush())
+	w.Write([]byte("second"))
+	require.NoError(t, w.Finalize())
+	res, body = readResponse(t, buf)
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Equal(t, "first second", body)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+
+	// Test: Explicit Content-Length is streamed as is
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.Headers.Set("Content-Length", "6")
+	w.Write([]byte("abc"))
+	require.NoError(t, w.Flush())
+	w.Write([]byte("def"))
+	require.NoError(t, w.Finalize())
+	res, body = readResponse(t, buf)
+	assert.Equal(t, "abcdef", body)
+	assert.Empty(t, res.TransferEncoding)
+}
+
+func TestContentLengthMismatch(t *testing.T) {
+	// Test: Writing past the declared length fails
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.Headers.Set("Content-Length", "3")
+	_, err := w.Write([]byte("abcd"))
+	require.ErrorIs(t, err, ErrContentLength)
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	_, err = w.Write([]byte("d"))
+	require.ErrorIs(t, err, ErrContentLength)
+	require.NoError(t, w.Finalize())
+
+	// Test: A short body is reported so the connection is not reused
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.Headers.Set("Content-Length", "10")
+	w.Write([]byte("abc"))
+	require.ErrorIs(t, w.Finalize(), ErrContentLength)
+
+	// Test: HEAD responses may declare a length without writing the body
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.SuppressBody()
+	w.Headers.Set("Content-Length", "10")
+	require.NoError(t, w.Finalize())
+	assert.Contains(t, buf.String(), "Content-Length: 10\r\n")
+}
+
+func TestBodilessStatus(t *testing.T) {
+	for _, status := range []StatusCode{StatusNoContent, StatusNotModified} {
+		buf := &bytes.Buffer{}
+		w := NewResponseWriter(buf)
+		require.NoError(t, w.WriteStatusLine(status))
+		w.Write([]byte("dropped"))
+		require.NoError(t, w.Flush())
+		require.NoError(t, w.Finalize())
+		assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+		assert.NotContains(t, buf.String(), "dropped")
+		assert.NotContains(t, buf.String(), "Content-Length")
+		assert.NotContains(t, buf.String(), "Content-Type")
+		assert.NotContains(t, buf.String(), "Transfer-Encoding")
+	}
+
+	// Test: 304 keeps a Content-Length set by the handler
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	w.Headers.Set("Content-Length", "42")
+	require.NoError(t, w.Finalize())
+	assert.Contains(t, buf.String(), "Content-Length: 42\r\n")
+}
+
+func TestChunkedHeaderCase(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.Headers.Set("Transfer-Encoding", "Chunked")
+	w.Write([]byte("abc"))
+	require.NoError(t, w.Finalize())
+	res, body := readResponse(t, buf)
+	assert.Equal(t, "abc", body)
+	assert.NotContains(t, buf.String(), "Content-Length")
+	assert.Equal(t, int64(-1), res.ContentLength)
+}
+
+func TestWriteChunkedBody(t *testing.T) {
+	// Test: The response is committed as chunked before the first chunk
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.WriteStatusLine(StatusOK)
+	w.Write([]byte("buffered "))
+	_, err := w.WriteChunkedBody([]byte("first "))
+	require.NoError(t, err)
+
+	// Test: Empty writes do not end the body
+	n, err := w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = w.WriteChunkedBody([]byte("more"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	require.NoError(t, w.Finalize())
+
+	assert.True(t, strings.HasSuffix(buf.String(), "4\r\nmore\r\n0\r\n\r\n"))
+	res, body := readResponse(t, buf)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "buffered first more", body)
+
+	// Test: HTTP/1.0 clients get the chunks as a plain body
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.SetVersion("1.0")
+	w.WriteChunkedBody([]byte("plain"))
+	require.NoError(t, w.Finalize())
+	res, body = readResponse(t, buf)
+	assert.Empty(t, res.TransferEncoding)
+	assert.Equal(t, "plain", body)
+}
+
+func TestSuppressBody(t *testing.T) {
+	// Test: Content-Length is kept without sending the body
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.SuppressBody()
+	large := strings.Repeat("x", BUFFER_SIZE*3)
+	io.Copy(w, strings.NewReader(large))
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finalize())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.Contains(t, buf.String(), fmt.Sprintf("Content-Length: %d\r\n", len(large)))
+	assert.NotContains(t, buf.String(), "Transfer-Encoding")
+
+	// Test: Chunked responses send no chunks or trailers
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.SuppressBody()
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	w.Write([]byte("payload"))
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc123"))
+	require.NoError(t, w.Finalize())
+	assert.Contains(t, buf.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.NotContains(t, buf.String(), "payload")
+	assert.NotContains(t, buf.String(), "abc123")
+}
+
+func TestHTTP10Response(t *testing.T) {
+	// Test: Status line uses the negotiated version
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.WriteInformational(StatusContinue, nil))
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.0 200 OK\r\n"))
+
+	// Test: Headers sent without a length close the connection
+	assert.Contains(t, buf.String(), "Connection: close\r\n")
+	assert.NotContains(t, buf.String(), "Transfer-Encoding")
+
+	// Test: Large and flushed bodies are buffered instead of chunked
+	buf = &bytes.Buffer{}
+	w = NewResponseWriter(buf)
+	w.SetVersion("1.0")
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	large := strings.Repeat("x", BUFFER_SIZE*3)
+	io.Copy(w, strings.NewReader(large))
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finalize())
+	res, body := readResponse(t, buf)
+	assert.Equal(t, "HTTP/1.0", res.Proto)
+	assert.Equal(t, large, body)
+	assert.Equal(t, int64(len(large)), res.ContentLength)
+	assert.Empty(t, res.TransferEncoding)
+	assert.Empty(t, res.Header.Get("Trailer"))
+}
+
+func TestTrailers(t *testing.T) {
+	// Test: Declared trailers follow the last chunk
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	require.NoError(t, w.DeclareTrailer("X-Checksum", "X-Length"))
+	w.Write([]byte("payload"))
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc123"))
+	require.NoError(t, w.SetTrailer("x-length", "7"))
+	require.Error(t, w.SetTrailer("X-Undeclared", "nope"))
+	require.NoError(t, w.Finalize())
+
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\nX-Checksum: abc123\r\nX-Length: 7\r\n\r\n"))
+	res, body := readResponse(t, buf)
+	assert.Equal(t, "payload", body)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "abc123", res.Trailer.Get("X-Checksum"))
+	assert.Equal(t, "7", res.Trailer.Get("X-Length"))
+
+	// Test: Trailers cannot be set once the body is terminated
+	require.ErrorIs(t, w.SetTrailer("X-Checksum", "late"), ErrBodyDone)
+	_, err := w.Write([]byte("late"))
+	require.ErrorIs(t, err, ErrBodyDone)
+
+	// Test: Framing fields cannot be trailers
+	w = NewResponseWriter(&bytes.Buffer{})
+	require.Error(t, w.DeclareTrailer("Content-Length"))
+
+	// Test: Trailers must be declared before headers are sent
+	w = NewResponseWriter(&bytes.Buffer{})
+	require.NoError(t, w.Flush())
+	require.Error(t, w.DeclareTrailer("X-Checksum"))
+}
+
+func TestWriteHeadersKeepsValues(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewResponseWriter(buf)
+	w.Headers.Set("Date", "Sat, 17 Oct 2026 10:00:00 GMT")
+	w.Headers.Add("Set-Cookie", "a=1")
+	w.Headers.Add("Set-Cookie", "b=2")
+	require.NoError(t, w.Finalize())
+
+	assert.Contains(t, buf.String(), "Date: Sat, 17 Oct 2026 10:00:00 GMT\r\nSet-Cookie: a=1\r\nSet-Cookie: b=2\r\n")
+}
@@ -0,0 +1,259 @@
+package router
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/crunchydeer30/httpfromtcp/internal/server"
+)
+
+type segmentKind int
+
+const (
+	literalSegment segmentKind = iota
+	paramSegment
+	wildcardSegment
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to handlers registered by method and path
+// pattern. Its ServeHTTP method is a server.Handler.
+type Router struct {
+	routes     []*route
+	middleware []server.Middleware
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for pattern. A pattern is an optional method
+// followed by a path, e.g. "GET /users/{id}" or "/static/{path...}". A
+// {name...} segment matches the rest of the path and must come last.
+// Handle panics if the pattern is malformed or already registered.
+func (rt *Router) Handle(pattern string, handler server.Handler) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	path = strings.TrimLeft(path, " ")
+
+	segments, err := parsePattern(path)
+	if err != nil {
+		panic(fmt.Sprintf("router: pattern %q: %v", pattern, err))
+	}
+
+	for _, r := range rt.routes {
+		if r.method == method && slices.Equal(r.segments, segments) {
+			panic(fmt.Sprintf("router: pattern %q is already registered", pattern))
+		}
+	}
+
+	rt.routes = append(rt.routes, &route{
+		method:   method,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+// Use appends middleware that wraps every request dispatched by the router,
+// including the ones answered with 404 or 405.
+func (rt *Router) Use(middleware ...server.Middleware) {
+	rt.middleware = append(rt.middleware, middleware...)
+}
+
+func (rt *Router) ServeHTTP(w *response.ResponseWriter, req *request.Request) {
+	server.Chain(rt.dispatch, rt.middleware...)(w, req)
+}
+
+func (rt *Router) dispatch(w *response.ResponseWriter, req *request.Request) {
+	method := req.RequestLine.Method
+	if req.URL.Form == request.AsteriskForm {
+		rt.writeOptions(w, rt.methods())
+		return
+	}
+
+	parts := req.URL.Segments()
+
+	var best *route
+	var bestParams map[string]string
+	var allowed []string
+	for _, r := range rt.routes {
+		params, ok := r.match(parts)
+		if !ok {
+			continue
+		}
+		if r.method != "" && !slices.Contains(allowed, r.method) {
+			allowed = append(allowed, r.method)
+		}
+		if !r.handles(method) {
+			continue
+		}
+		if best == nil || r.moreSpecificThan(best, method) {
+			best, bestParams = r, params
+		}
+	}
+
+	if best == nil {
+		if method == "OPTIONS" && len(allowed) > 0 {
+			rt.writeOptions(w, allowed)
+			return
+		}
+		if len(allowed) > 0 {
+			w.WriteStatusLine(response.StatusMethodNotAllowed)
+			w.Headers.Set("Allow", allowHeader(allowed))
+			w.Write([]byte("405 method not allowed\n"))
+			return
+		}
+		w.WriteStatusLine(response.StatusNotFound)
+		w.Write([]byte("404 page not found\n"))
+		return
+	}
+
+	for name, value := range bestParams {
+		req.SetPathValue(name, value)
+	}
+	best.handler(w, req)
+}
+
+// writeOptions answers an OPTIONS request that no route handles itself.
+func (rt *Router) writeOptions(w *response.ResponseWriter, allowed []string) {
+	w.WriteStatusLine(response.StatusOK)
+	w.Headers.Set("Allow", allowHeader(allowed))
+}
+
+// methods returns every method some route is registered for.
+func (rt *Router) methods() []string {
+	var methods []string
+	for _, r := range rt.routes {
+		if r.method != "" && !slices.Contains(methods, r.method) {
+			methods = append(methods, r.method)
+		}
+	}
+	return methods
+}
+
+// allowHeader formats an Allow value for the registered methods, adding
+// the HEAD and OPTIONS requests the router answers on their behalf.
+func allowHeader(methods []string) string {
+	methods = slices.Clone(methods)
+	if slices.Contains(methods, "GET") && !slices.Contains(methods, "HEAD") {
+		methods = append(methods, "HEAD")
+	}
+	if !slices.Contains(methods, "OPTIONS") {
+		methods = append(methods, "OPTIONS")
+	}
+	slices.Sort(methods)
+	return strings.Join(methods, ", ")
+}
+
+func parsePattern(path string) ([]segment, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path must start with /")
+	}
+
+	parts := splitPath(path)
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, fmt.Errorf("segment %q mixes text and a parameter", part)
+			}
+			segments = append(segments, segment{kind: literalSegment, value: part})
+			continue
+		}
+
+		name := part[1 : len(part)-1]
+		kind := paramSegment
+		if strings.HasSuffix(name, "...") {
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("%s must be the last segment", part)
+			}
+			name = strings.TrimSuffix(name, "...")
+			kind = wildcardSegment
+		}
+		if name == "" {
+			return nil, fmt.Errorf("empty parameter name")
+		}
+		segments = append(segments, segment{kind: kind, value: name})
+	}
+	return segments, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+func (r *route) match(parts []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, seg := range r.segments {
+		if seg.kind == wildcardSegment {
+			params[seg.value] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case literalSegment:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case paramSegment:
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[seg.value] = parts[i]
+		}
+	}
+	if len(parts) != len(r.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// handles reports whether the route serves method. GET routes also serve
+// HEAD unless a HEAD route is registered for the same path.
+func (r *route) handles(method string) bool {
+	return r.method == "" || r.method == method || (method == "HEAD" && r.method == "GET")
+}
+
+// moreSpecificThan prefers literal segments over parameters and parameters
+// over wildcards, comparing from the left. Among routes with the same
+// shape, an exact method match wins over a GET route serving HEAD, which
+// wins over a method-less route.
+func (r *route) moreSpecificThan(other *route, method string) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+	if len(r.segments) != len(other.segments) {
+		return len(r.segments) > len(other.segments)
+	}
+	return r.methodRank(method) > other.methodRank(method)
+}
+
+func (r *route) methodRank(method string) int {
+	switch r.method {
+	case method:
+		return 2
+	case "":
+		return 0
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,173 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/crunchydeer30/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, rt *Router, method, target string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	w := response.NewResponseWriter(buf)
+	if method == "HEAD" {
+		w.SuppressBody()
+	}
+	rt.ServeHTTP(w, req)
+	require.NoError(t, w.Finalize())
+
+	res, err := http.ReadResponse(bufio.NewReader(buf), &http.Request{Method: method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func writeText(text string) func(w *response.ResponseWriter, req *request.Request) {
+	return func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Write([]byte(text))
+	}
+}
+
+func TestRouterMatching(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Write([]byte("user " + req.PathValue("id")))
+	})
+	rt.Handle("GET /users/me", writeText("me"))
+	rt.Handle("POST /users", writeText("created"))
+	rt.Handle("/static/{path...}", func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Write([]byte("static " + req.PathValue("path")))
+	})
+
+	// Test: Path parameter
+	res, body := serve(t, rt, "GET", "/users/42")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "user 42", body)
+
+	// Test: Literal segment wins over parameter
+	_, body = serve(t, rt, "GET", "/users/me")
+	assert.Equal(t, "me", body)
+
+	// Test: Query string is ignored
+	_, body = serve(t, rt, "GET", "/users/7?verbose=1")
+	assert.Equal(t, "user 7", body)
+
+	// Test: Wildcard matches the rest of the path for any method
+	_, body = serve(t, rt, "DELETE", "/static/css/site.css")
+	assert.Equal(t, "static css/site.css", body)
+
+	// Test: Parameters match encoded slashes within one segment
+	_, body = serve(t, rt, "GET", "/users/a%2Fb")
+	assert.Equal(t, "user a/b", body)
+	res, _ = serve(t, rt, "GET", "/users/42%2F..%2F..%2Fstatic")
+	assert.Equal(t, 200, res.StatusCode)
+	_, body = serve(t, rt, "GET", "/static/x/%2e%2e/%2e%2e/users/me")
+	assert.Equal(t, "me", body)
+
+	// Test: Method match
+	_, body = serve(t, rt, "POST", "/users")
+	assert.Equal(t, "created", body)
+
+	// Test: Not found
+	res, _ = serve(t, rt, "GET", "/teapot")
+	assert.Equal(t, 404, res.StatusCode)
+
+	// Test: Empty parameter does not match
+	res, _ = serve(t, rt, "GET", "/users/")
+	assert.Equal(t, 404, res.StatusCode)
+
+	// Test: Method not allowed
+	res, _ = serve(t, rt, "PUT", "/users/42")
+	assert.Equal(t, 405, res.StatusCode)
+	assert.Equal(t, []string{"GET, HEAD, OPTIONS"}, res.Header.Values("Allow"))
+}
+
+func TestRouterHeadAndOptions(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", writeText("user"))
+	rt.Handle("DELETE /users/{id}", writeText("deleted"))
+	rt.Handle("GET /feed", writeText("get feed"))
+	rt.Handle("HEAD /feed", func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Headers.Set("X-Head", "1")
+	})
+	rt.Handle("OPTIONS /cors", writeText("preflight"))
+	rt.Handle("POST /cors", writeText("posted"))
+
+	// Test: HEAD runs the GET handler without a body
+	res, body := serve(t, rt, "HEAD", "/users/1")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, int64(len("user")), res.ContentLength)
+	assert.Empty(t, body)
+
+	// Test: An explicit HEAD route wins over GET
+	res, _ = serve(t, rt, "HEAD", "/feed")
+	assert.Equal(t, "1", res.Header.Get("X-Head"))
+
+	// Test: OPTIONS lists the methods registered for the path
+	res, body = serve(t, rt, "OPTIONS", "/users/1")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", res.Header.Get("Allow"))
+	assert.Empty(t, body)
+
+	// Test: An explicit OPTIONS route is used as is
+	_, body = serve(t, rt, "OPTIONS", "/cors")
+	assert.Equal(t, "preflight", body)
+
+	// Test: OPTIONS * lists every registered method
+	res, _ = serve(t, rt, "OPTIONS", "*")
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS, POST", res.Header.Get("Allow"))
+
+	// Test: OPTIONS for an unknown path
+	res, _ = serve(t, rt, "OPTIONS", "/teapot")
+	assert.Equal(t, 404, res.StatusCode)
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /a/{id}", writeText("a"))
+
+	assert.Panics(t, func() { rt.Handle("GET /a/{id}", writeText("a")) })
+	assert.Panics(t, func() { rt.Handle("GET a/b", writeText("a")) })
+	assert.Panics(t, func() { rt.Handle("GET /a/{rest...}/b", writeText("a")) })
+	assert.Panics(t, func() { rt.Handle("GET /a/x{id}", writeText("a")) })
+	assert.Panics(t, func() { rt.Handle("GET /a/{}", writeText("a")) })
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /hello", writeText("hello"))
+
+	var seen []response.StatusCode
+	rt.Use(func(next server.Handler) server.Handler {
+		return func(w *response.ResponseWriter, req *request.Request) {
+			w.Headers.Set("X-Middleware", "1")
+			next(w, req)
+			seen = append(seen, w.StatusCode())
+		}
+	})
+
+	res, body := serve(t, rt, "GET", "/hello")
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "1", res.Header.Get("X-Middleware"))
+
+	res, _ = serve(t, rt, "GET", "/nope")
+	assert.Equal(t, "1", res.Header.Get("X-Middleware"))
+	assert.Equal(t, []response.StatusCode{response.StatusOK, response.StatusNotFound}, seen)
+}
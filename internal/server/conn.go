@@ -0,0 +1,147 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+)
+
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connReader sits between a connection and the request parser. While a
+// handler runs it can keep a read pending on the connection to notice the
+// client hanging up, handing any byte it reads to the next request.
+type connReader struct {
+	conn net.Conn
+
+	mu      sync.Mutex
+	inRead  bool
+	aborted bool
+	hasByte bool
+	byteBuf [1]byte
+	done    chan struct{}
+}
+
+func newConnReader(conn net.Conn) *connReader {
+	return &connReader{conn: conn}
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	cr.mu.Lock()
+	if cr.hasByte {
+		p[0] = cr.byteBuf[0]
+		cr.hasByte = false
+		cr.mu.Unlock()
+		return 1, nil
+	}
+	cr.mu.Unlock()
+
+	return cr.conn.Read(p)
+}
+
+// startBackgroundRead calls onHangUp if the connection fails or is closed
+// by the client before abortPendingRead is called.
+func (cr *connReader) startBackgroundRead(onHangUp func()) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.inRead = true
+	cr.aborted = false
+	cr.done = make(chan struct{})
+	go cr.backgroundRead(onHangUp)
+}
+
+func (cr *connReader) backgroundRead(onHangUp func()) {
+	n, err := cr.conn.Read(cr.byteBuf[:])
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if n == 1 {
+		cr.hasByte = true
+	}
+	if err != nil && !cr.aborted {
+		onHangUp()
+	}
+	cr.inRead = false
+	close(cr.done)
+}
+
+func (cr *connReader) abortPendingRead() {
+	cr.mu.Lock()
+	if !cr.inRead {
+		cr.mu.Unlock()
+		return
+	}
+	cr.aborted = true
+	cr.conn.SetReadDeadline(aLongTimeAgo)
+	done := cr.done
+	cr.mu.Unlock()
+
+	<-done
+	cr.conn.SetReadDeadline(time.Time{})
+}
+
+// expectContinueReader sends 100 Continue the first time the handler reads
+// a body the client holds back until it is asked for it. A handler that
+// answers without reading the body never triggers it.
+type expectContinueReader struct {
+	io.ReadCloser
+	w       *response.ResponseWriter
+	started bool
+	sent    bool
+}
+
+func (ecr *expectContinueReader) Read(p []byte) (int, error) {
+	if !ecr.started {
+		ecr.started = true
+		// This fails if the handler flushed its response before reading the
+		// body. The client then only sends the body once its own wait runs
+		// out, if at all, and sent stays false so the connection is closed.
+		ecr.sent = ecr.w.WriteInformational(response.StatusContinue, nil) == nil
+	}
+	return ecr.ReadCloser.Read(p)
+}
+
+// bodyReader follows the handler reading the request body. onDone is
+// called once the body has been read to EOF, after which the connection
+// stays idle until the response is written, so a read on it can watch for
+// the client hanging up. onError is called with the first error reading
+// the body, including while Close discards what the handler left.
+type bodyReader struct {
+	io.ReadCloser
+	onDone  func()
+	onError func(error)
+	done    bool
+}
+
+func (br *bodyReader) Read(p []byte) (int, error) {
+	n, err := br.ReadCloser.Read(p)
+	br.observe(err)
+	return n, err
+}
+
+func (br *bodyReader) Close() error {
+	err := br.ReadCloser.Close()
+	br.observe(err)
+	return err
+}
+
+func (br *bodyReader) observe(err error) {
+	if br.done || err == nil || errors.Is(err, request.ErrBodyClosed) || errors.Is(err, request.ErrBodyNotDiscarded) {
+		return
+	}
+	br.done = true
+	if err == io.EOF {
+		br.onDone()
+	} else {
+		br.onError(err)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+)
+
+// ErrorHandler writes the response for a request that could not be read,
+// or whose handler panicked before sending a status, in which case err is
+// a *PanicError. The connection is closed once it returns.
+type ErrorHandler func(w *response.ResponseWriter, status response.StatusCode, err error)
+
+// TextErrorHandler answers with the reason phrase and, for parse errors,
+// what was wrong with the request as plain text. It is the default.
+func TextErrorHandler(w *response.ResponseWriter, status response.StatusCode, err error) {
+	w.WriteStatusLine(status)
+	w.Headers.Set("Content-Type", "text/plain; charset=utf-8")
+	if detail := errorDetail(err); detail != "" {
+		fmt.Fprintf(w, "%s %s: %s\n", status, status.StatusText(), detail)
+		return
+	}
+	fmt.Fprintf(w, "%s %s\n", status, status.StatusText())
+}
+
+// PanicError describes a panic recovered from a handler.
+type PanicError struct {
+	// Value is what the handler panicked with.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns Value if the handler panicked with an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	State  string `json:"state,omitempty"`
+	Offset *int   `json:"offset,omitempty"`
+}
+
+// ProblemJSONErrorHandler answers with an RFC 9457 application/problem+json
+// document. Parse errors add the parser state and byte offset as extension
+// members.
+func ProblemJSONErrorHandler(w *response.ResponseWriter, status response.StatusCode, err error) {
+	p := problem{
+		Type:   "about:blank",
+		Title:  status.StatusText(),
+		Status: int(status),
+		Detail: errorDetail(err),
+	}
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		p.State = parseErr.State.String()
+		p.Offset = &parseErr.Offset
+	}
+
+	w.WriteStatusLine(status)
+	w.Headers.Set("Content-Type", "application/problem+json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// errorDetail only describes parse errors, so that I/O errors and the like
+// do not leak to the client.
+func errorDetail(err error) string {
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.Err.Error()
+	}
+	return ""
+}
+
+// statusForError picks the response status for a request that could not
+// be read.
+func statusForError(err error) response.StatusCode {
+	var parseErr *request.ParseError
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return response.StatusRequestTimeout
+	case errors.As(err, &parseErr):
+		return response.StatusCode(parseErr.Status)
+	default:
+		return response.StatusBadRequest
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd, see
+// sd_listen_fds(3).
+const listenFdsStart = 3
+
+// Listen listens on address, which is either a TCP address such as ":8080"
+// or "127.0.0.1:0", or "unix:" followed by the path of a Unix socket.
+func Listen(address string) (net.Listener, error) {
+	if path, found := strings.CutPrefix(address, "unix:"); found {
+		return ListenUnix(path, 0)
+	}
+	return net.Listen("tcp", address)
+}
+
+// ListenUnix listens on a Unix domain socket at path. Unless mode is zero
+// the socket permissions are set to mode, e.g. 0o660 to only let a group
+// connect. A socket file left behind by a process that is gone is removed
+// first; one still accepting connections is left alone and reported.
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	if mode == 0 {
+		return net.Listen("unix", path)
+	}
+
+	// The socket is created in a private directory and only linked to path
+	// once it has its permissions, so no one can connect in between.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	// Unlike a rename, a link never replaces a socket that is in use.
+	if err := os.Link(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: l, path: path}, nil
+}
+
+// unixListener reports and removes its socket under the path it was linked
+// to rather than the one it was created at.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	if err := l.UnixListener.Close(); err != nil {
+		return err
+	}
+	return os.Remove(l.path)
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return nil
+	}
+
+	// Only a refused connection shows that no one is listening. Any other
+	// error, such as lacking permission, says nothing about the owner.
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return nil
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// SystemdListeners returns the sockets passed by systemd socket activation
+// in the order they are configured, or nil if the process was not socket
+// activated. The LISTEN_* variables are unset so that child processes do
+// not pick the sockets up again.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds := os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("server: invalid LISTEN_FDS %q", fds)
+	}
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+	return listenersFromFDs(listenFdsStart, n, fdNames)
+}
+
+// listenersFromFDs turns n inherited descriptors starting at start into
+// listeners. The descriptors are closed; the listeners use duplicates.
+func listenersFromFDs(start, n int, names []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := start + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("server: socket %s: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,120 @@
+//go:build unix
+
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// get sends a request on a new connection to addr and returns the body.
+func get(t *testing.T, addr net.Addr, target string) string {
+	t.Helper()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestServeListener(t *testing.T) {
+	s := New(echoTargetHandler)
+	assert.Nil(t, s.Addr())
+
+	l, err := Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+
+	require.Eventually(t, func() bool { return s.Addr() != nil }, time.Second, shutdownPollInterval)
+	assert.Equal(t, l.Addr(), s.Addr())
+	assert.Equal(t, "/hello", get(t, s.Addr(), "/hello"))
+
+	// Test: Serve returns once the server is closed
+	require.NoError(t, s.Close())
+	require.ErrorIs(t, <-done, ErrServerClosed)
+	require.ErrorIs(t, s.Serve(l), ErrServerClosed)
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "httpfromtcp.sock")
+
+	// Test: A stale socket file is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := ListenUnix(path, 0o600)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	s := New(echoTargetHandler)
+	defer s.Close()
+	go s.Serve(l)
+	require.Eventually(t, func() bool { return s.Addr() != nil }, time.Second, shutdownPollInterval)
+	assert.Equal(t, "/unix", get(t, s.Addr(), "/unix"))
+
+	// Test: A socket in use is not taken over
+	_, err = Listen("unix:" + path)
+	require.Error(t, err)
+	_, err = ListenUnix(path, 0o600)
+	require.Error(t, err)
+
+	// Test: Closing removes the socket
+	require.NoError(t, s.Close())
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSystemdListeners(t *testing.T) {
+	// Test: Variables meant for another process are ignored
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := SystemdListeners()
+	require.NoError(t, err)
+	assert.Nil(t, listeners)
+
+	// Test: Inherited descriptors become listeners
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	fd, err := syscall.Dup(int(f.Fd()))
+	require.NoError(t, err)
+	f.Close()
+
+	listeners, err = listenersFromFDs(fd, 1, []string{"http"})
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	assert.Equal(t, l.Addr().String(), listeners[0].Addr().String())
+
+	s := New(echoTargetHandler)
+	defer s.Close()
+	go s.Serve(listeners[0])
+	require.Eventually(t, func() bool { return s.Addr() != nil }, time.Second, shutdownPollInterval)
+	assert.Equal(t, "/activated", get(t, s.Addr(), "/activated"))
+}
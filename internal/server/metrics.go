@@ -0,0 +1,215 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/headers"
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+)
+
+// durationBuckets are the upper bounds in seconds of the request duration
+// histogram, the Prometheus client defaults.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// parseErrors are the errors counted by type, whether they end the headers
+// or come up while the body is read. Using a fixed list keeps the number of
+// label values bounded.
+var parseErrors = []error{
+	request.ErrMalformedRequestLine,
+	request.ErrMalformedRequestTarget,
+	request.ErrUnsupportedHttpVersion,
+	request.ErrUnsupportedHttpMethod,
+	request.ErrRequestLineTooLong,
+	headers.ErrMalformedHeader,
+	request.ErrHeaderLineTooLong,
+	request.ErrHeadersTooLarge,
+	request.ErrTooManyHeaders,
+	request.ErrMissingHost,
+	request.ErrDuplicateHost,
+	request.ErrInvalidHost,
+	request.ErrUnsupportedExpectation,
+	request.ErrInvalidContentLength,
+	request.ErrConflictingContentLength,
+	request.ErrConflictingFraming,
+	request.ErrInvalidTransferEncoding,
+	request.ErrUnsupportedTransferEncoding,
+	request.ErrMalformedChunk,
+	request.ErrBodyTooLong,
+	request.ErrIncompleteData,
+}
+
+type requestKey struct {
+	method      string
+	statusClass string
+}
+
+// metrics holds the counters a Server keeps about itself. They are
+// exposed in the Prometheus text format on MetricsPath.
+type metrics struct {
+	activeConns   atomic.Int64
+	acceptedConns atomic.Int64
+	closedConns   atomic.Int64
+	bytesIn       atomic.Int64
+	bytesOut      atomic.Int64
+
+	mu            sync.Mutex
+	requests      map[requestKey]int64
+	buckets       []int64
+	durationSum   float64
+	durationCount int64
+	parseErrors   map[string]int64
+}
+
+func (m *metrics) connOpened() {
+	m.acceptedConns.Add(1)
+	m.activeConns.Add(1)
+}
+
+func (m *metrics) connClosed() {
+	m.closedConns.Add(1)
+	m.activeConns.Add(-1)
+}
+
+func (m *metrics) observeRequest(method string, status response.StatusCode, d time.Duration) {
+	if !slices.Contains(request.Methods, method) {
+		method = "OTHER"
+	}
+	key := requestKey{method: method, statusClass: fmt.Sprintf("%dxx", status/100)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.requests == nil {
+		m.requests = map[requestKey]int64{}
+		m.buckets = make([]int64, len(durationBuckets))
+	}
+	m.requests[key]++
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+func (m *metrics) observeParseError(err error) {
+	kind := "other"
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		kind = "timeout"
+	}
+	for _, parseErr := range parseErrors {
+		if errors.Is(err, parseErr) {
+			kind = strings.ReplaceAll(parseErr.Error(), " ", "_")
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.parseErrors == nil {
+		m.parseErrors = map[string]int64{}
+	}
+	m.parseErrors[kind]++
+}
+
+// countingConn counts the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	metrics *metrics
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.metrics.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.metrics.bytesOut.Add(int64(n))
+	return n, err
+}
+
+// WriteMetrics writes the server metrics in the Prometheus text exposition
+// format. It is what the server answers on MetricsPath, and can be
+// registered on a router to serve them elsewhere.
+func (s *Server) WriteMetrics(w *response.ResponseWriter, req *request.Request) {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		w.WriteStatusLine(response.StatusMethodNotAllowed)
+		w.Headers.Set("Allow", "GET, HEAD")
+		w.Write([]byte("405 method not allowed\n"))
+		return
+	}
+
+	m := &s.metrics
+	var b strings.Builder
+	writeMetric(&b, "http_server_active_connections", "gauge", "Connections currently open.")
+	fmt.Fprintf(&b, "http_server_active_connections %d\n", m.activeConns.Load())
+	writeMetric(&b, "http_server_connections_accepted_total", "counter", "Connections accepted.")
+	fmt.Fprintf(&b, "http_server_connections_accepted_total %d\n", m.acceptedConns.Load())
+	writeMetric(&b, "http_server_connections_closed_total", "counter", "Connections closed.")
+	fmt.Fprintf(&b, "http_server_connections_closed_total %d\n", m.closedConns.Load())
+	writeMetric(&b, "http_server_received_bytes_total", "counter", "Bytes read from clients.")
+	fmt.Fprintf(&b, "http_server_received_bytes_total %d\n", m.bytesIn.Load())
+	writeMetric(&b, "http_server_sent_bytes_total", "counter", "Bytes written to clients.")
+	fmt.Fprintf(&b, "http_server_sent_bytes_total %d\n", m.bytesOut.Load())
+
+	m.mu.Lock()
+	writeMetric(&b, "http_server_requests_total", "counter", "Requests served by method and status class.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		return strings.Compare(a.method+" "+a.statusClass, b.method+" "+b.statusClass)
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&b, "http_server_requests_total{method=%q,status=%q} %d\n", key.method, key.statusClass, m.requests[key])
+	}
+
+	writeMetric(&b, "http_server_request_duration_seconds", "histogram", "Time from the end of the request headers to the end of the response.")
+	for i, bound := range durationBuckets {
+		var count int64
+		if m.buckets != nil {
+			count = m.buckets[i]
+		}
+		fmt.Fprintf(&b, "http_server_request_duration_seconds_bucket{le=%q} %d\n", formatFloat(bound), count)
+	}
+	fmt.Fprintf(&b, "http_server_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(&b, "http_server_request_duration_seconds_sum %s\n", formatFloat(m.durationSum))
+	fmt.Fprintf(&b, "http_server_request_duration_seconds_count %d\n", m.durationCount)
+
+	writeMetric(&b, "http_server_parse_errors_total", "counter", "Requests that could not be parsed, by error.")
+	kinds := make([]string, 0, len(m.parseErrors))
+	for kind := range m.parseErrors {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "http_server_parse_errors_total{error=%q} %d\n", kind, m.parseErrors[kind])
+	}
+	m.mu.Unlock()
+
+	w.WriteStatusLine(response.StatusOK)
+	w.Headers.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+func writeMetric(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
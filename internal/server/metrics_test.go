@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	readBody := func(w *response.ResponseWriter, req *request.Request) {
+		io.Copy(io.Discard, req.Body)
+		echoTargetHandler(w, req)
+	}
+	s, err := Serve(0, readBody, WithMetricsPath("/metrics"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	send := func(raw string) *http.Response {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		return res
+	}
+
+	send("GET /one HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	send("POST /two HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\nConnection: close\r\n\r\nabc")
+	send("BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	send("GET / HTTP/1.1\r\nBroken header\r\n\r\n")
+	send("PUT /three HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\nzz\r\n")
+
+	res := send("GET /metrics HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", res.Header.Get("Content-Type"))
+
+	metrics := string(body)
+	assert.Regexp(t, `# TYPE http_server_active_connections gauge\nhttp_server_active_connections [1-6]\n`, metrics)
+	assert.Contains(t, metrics, "http_server_connections_accepted_total 6\n")
+	assert.Contains(t, metrics, `http_server_requests_total{method="GET",status="2xx"} 1`+"\n")
+	assert.Contains(t, metrics, `http_server_requests_total{method="POST",status="2xx"} 1`+"\n")
+	assert.Contains(t, metrics, "# TYPE http_server_request_duration_seconds histogram\n")
+	assert.Contains(t, metrics, `http_server_request_duration_seconds_bucket{le="+Inf"} 3`+"\n")
+	assert.Contains(t, metrics, "http_server_request_duration_seconds_count 3\n")
+	assert.Contains(t, metrics, `http_server_parse_errors_total{error="malformed_header"} 1`+"\n")
+	assert.Contains(t, metrics, `http_server_parse_errors_total{error="unsupported_http_method"} 1`+"\n")
+	assert.Contains(t, metrics, `http_server_parse_errors_total{error="malformed_chunk"} 1`+"\n")
+	assert.Regexp(t, `http_server_received_bytes_total [1-9]\d*\n`, metrics)
+	assert.Regexp(t, `http_server_sent_bytes_total [1-9]\d*\n`, metrics)
+
+	// Test: Only GET and HEAD are answered
+	res = send("POST /metrics HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, 405, res.StatusCode)
+}
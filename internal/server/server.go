@@ -1,10 +1,18 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/crunchydeer30/httpfromtcp/internal/request"
 	"github.com/crunchydeer30/httpfromtcp/internal/response"
@@ -12,67 +20,648 @@ import (
 
 type Handler func(w *response.ResponseWriter, req *request.Request)
 
+// Middleware wraps a Handler with behaviour that runs around it.
+type Middleware func(next Handler) Handler
+
+// Chain wraps handler with middleware so that the first middleware is the
+// outermost one and runs first.
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
 type Server struct {
-	listener net.Listener
-	handler  Handler
-	closed   atomic.Bool
+	// ReadHeaderTimeout bounds the time to read the request line and
+	// headers. A request that does not arrive in time gets a 408.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time to read the whole request, body included.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time from the end of the headers to the end of
+	// the response.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds the wait for the next request on a keep-alive
+	// connection. ReadTimeout is used when it is zero.
+	IdleTimeout time.Duration
+	// Limits bounds the size of request lines, headers and bodies. Zero
+	// fields fall back to request.DefaultLimits.
+	Limits request.Limits
+	// AllowExtensionMethods accepts any token as a request method instead
+	// of answering methods outside request.Methods with 501.
+	AllowExtensionMethods bool
+	// ErrorHandler renders the response to requests that cannot be parsed.
+	// It defaults to TextErrorHandler.
+	ErrorHandler ErrorHandler
+	// BaseContext is the parent of every request context. It defaults to
+	// context.Background().
+	BaseContext context.Context
+	// PanicHook is called after a handler panic has been recovered and
+	// logged, e.g. to forward it to an error reporting service.
+	PanicHook func(req *request.Request, err *PanicError)
+	// MetricsPath is where the server answers with its metrics in the
+	// Prometheus text format, ahead of the handler but behind middleware.
+	// Metrics are not exposed when it is empty.
+	MetricsPath string
+	// TLSConfig is the base configuration for ServeTLS. It is cloned, and
+	// its certificates are replaced if certificate files are given.
+	TLSConfig *tls.Config
+
+	certificates []certFiles
+
+	handler Handler
+	closed  atomic.Bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu         sync.RWMutex
+	middleware []Middleware
+
+	connsMu   sync.Mutex
+	conns     map[net.Conn]connStatus
+	listeners []net.Listener
+
+	metrics metrics
+}
+
+type connState int
+
+const (
+	// connNew is a connection whose first request has not arrived yet.
+	connNew connState = iota
+	connIdle
+	connActive
+)
+
+type connStatus struct {
+	state connState
+	since time.Time
+}
+
+const shutdownPollInterval = 10 * time.Millisecond
+
+// newConnGracePeriod is how long Shutdown waits for the first request on a
+// connection accepted just before it, as the bytes may still be in flight.
+const newConnGracePeriod = 5 * time.Second
+
+// Option configures a Server before it starts accepting connections.
+type Option func(*Server)
+
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(s *Server) { s.ReadHeaderTimeout = d }
+}
+
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) { s.ReadTimeout = d }
+}
+
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Server) { s.WriteTimeout = d }
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) { s.IdleTimeout = d }
+}
+
+func WithLimits(limits request.Limits) Option {
+	return func(s *Server) { s.Limits = limits }
+}
+
+func WithExtensionMethods() Option {
+	return func(s *Server) { s.AllowExtensionMethods = true }
+}
+
+func WithErrorHandler(errorHandler ErrorHandler) Option {
+	return func(s *Server) { s.ErrorHandler = errorHandler }
+}
+
+func WithBaseContext(ctx context.Context) Option {
+	return func(s *Server) { s.BaseContext = ctx }
+}
+
+func WithPanicHook(hook func(req *request.Request, err *PanicError)) Option {
+	return func(s *Server) { s.PanicHook = hook }
+}
+
+func WithMetricsPath(path string) Option {
+	return func(s *Server) { s.MetricsPath = path }
+}
+
+// WithTLSConfig sets the base TLS configuration for ServeTLS, e.g. to
+// require client certificates.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) { s.TLSConfig = config }
+}
+
+// WithCertificate adds a certificate and key pair for ServeTLS. With
+// several pairs the one matching the server name requested by the client
+// is used.
+func WithCertificate(certFile, keyFile string) Option {
+	return func(s *Server) { s.certificates = append(s.certificates, certFiles{certFile, keyFile}) }
+}
+
+// ErrServerClosed is returned by Server.Serve once the server is closed or
+// shut down.
+var ErrServerClosed = errors.New("server: closed")
+
+// Serve listens on the TCP port and serves connections in the background.
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 
 	if err != nil {
 		return nil, err
 	}
 
+	server := New(handler, opts...)
+	server.trackListener(listener)
+	go server.serve(listener)
+	return server, nil
+}
+
+// ServeTLS is like Serve but speaks TLS. The certificate and key files
+// are reloaded when they change on disk. They can be left empty if
+// WithCertificate or WithTLSConfig provides the certificates instead.
+func ServeTLS(port int, handler Handler, certFile, keyFile string, opts ...Option) (*Server, error) {
+	server := New(handler, opts...)
+	config, err := server.tlsConfig(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+
+	tlsListener := tls.NewListener(listener, config)
+	server.trackListener(tlsListener)
+	go server.serve(tlsListener)
+	return server, nil
+}
+
+// New returns a Server for handler that is not serving yet. Connections
+// are accepted once Serve or ServeTLS is called with a listener.
+func New(handler Handler, opts ...Option) *Server {
 	server := &Server{
-		listener: listener,
-		handler:  handler,
-		closed:   atomic.Bool{},
+		handler: handler,
+		closed:  atomic.Bool{},
+		conns:   map[net.Conn]connStatus{},
 	}
 	server.closed.Store(false)
-	go server.listen()
+	for _, opt := range opts {
+		opt(server)
+	}
+	baseCtx := server.BaseContext
+	if baseCtx == nil {
+		baseCtx = context.Background()
+	}
+	server.ctx, server.cancel = context.WithCancel(baseCtx)
+	return server
+}
 
-	return server, nil
+// Serve accepts connections on l until the server is closed, and then
+// returns ErrServerClosed. It can be called for several listeners at once.
+func (s *Server) Serve(l net.Listener) error {
+	if !s.trackListener(l) {
+		l.Close()
+		return ErrServerClosed
+	}
+	return s.serve(l)
+}
+
+// ServeTLS is like Serve but wraps l in TLS, see the ServeTLS function for
+// how certificates are configured.
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	return s.Serve(tls.NewListener(l, config))
+}
+
+// Addr returns the address of the first listener being served, which
+// tells the port picked for ":0" or the path of a Unix socket. It returns
+// nil before serving starts.
+func (s *Server) Addr() net.Addr {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
 }
 
+func (s *Server) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+
+	certificates := s.certificates
+	if certFile != "" || keyFile != "" {
+		certificates = append([]certFiles{{certFile, keyFile}}, certificates...)
+	}
+	if len(certificates) > 0 {
+		store, err := newCertStore(certificates)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = nil
+		config.GetCertificate = store.GetCertificate
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("server: no TLS certificate configured")
+	}
+	return config, nil
+}
+
+// Use appends middleware to the stack wrapped around the server handler.
+func (s *Server) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+}
+
+func (s *Server) chain() Handler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Chain(s.route, s.middleware...)
+}
+
+// route sends requests for MetricsPath to WriteMetrics and everything else
+// to the server handler.
+func (s *Server) route(w *response.ResponseWriter, req *request.Request) {
+	if s.MetricsPath != "" && req.URL.Path == s.MetricsPath {
+		s.WriteMetrics(w, req)
+		return
+	}
+	s.handler(w, req)
+}
+
+// Close stops accepting connections and closes every open connection,
+// including the ones with requests in flight, cancelling their contexts.
 func (s *Server) Close() error {
 	s.closed.Store(true)
-	return s.listener.Close()
+	s.cancel()
+	err := s.closeListeners()
+
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
 }
 
-func (s *Server) listen() {
+// Shutdown stops accepting connections, closes idle ones and waits for the
+// requests in flight to finish. If ctx expires first, the remaining
+// connections are closed and the context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closed.Store(true)
+	err := s.closeListeners()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
 	for {
-		conn, err := s.listener.Accept()
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes the connections waiting for a request and reports
+// whether no connections are left.
+func (s *Server) closeIdleConns() bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	for conn, status := range s.conns {
+		expired := status.state == connNew && time.Since(status.since) > newConnGracePeriod
+		if status.state == connIdle || expired {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+	return len(s.conns) == 0
+}
+
+// setConnState records the state of conn. It reports false if the server
+// is shutting down and conn should not start another request. Only the
+// first request of a connection accepted before shutdown is still served.
+func (s *Server) setConnState(conn net.Conn, state connState) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	status, tracked := s.conns[conn]
+	if s.closed.Load() && !(state == connActive && tracked && status.state == connNew) {
+		return false
+	}
+	s.conns[conn] = connStatus{state: state, since: time.Now()}
+	return true
+}
+
+func (s *Server) forgetConn(conn net.Conn) {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	delete(s.conns, conn)
+}
+
+// trackListener records l so that Close can stop it. It reports false if
+// the server is already closed.
+func (s *Server) trackListener(l net.Listener) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if s.closed.Load() {
+		return false
+	}
+	s.listeners = append(s.listeners, l)
+	return true
+}
+
+func (s *Server) closeListeners() error {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	var err error
+	for _, l := range s.listeners {
+		if closeErr := l.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (s *Server) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
 		if err != nil {
 			if s.closed.Load() {
-				return
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
 			}
 			log.Println("error accepting TCP connection", err)
 			continue
 		}
+		if !s.setConnState(conn, connNew) {
+			conn.Close()
+			continue
+		}
 		go s.handle(conn)
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
+	s.metrics.connOpened()
+	defer s.metrics.connClosed()
 	defer conn.Close()
+	defer s.forgetConn(conn)
 
-	responseWriter := response.NewResponseWriter(conn)
+	connCtx, cancelConn := context.WithCancel(s.ctx)
+	defer cancelConn()
+
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state, err := s.handshake(connCtx, tlsConn)
+		if err != nil {
+			log.Println("TLS handshake error:", err)
+			return
+		}
+		tlsState = state
+	}
+
+	counted := &countingConn{Conn: conn, metrics: &s.metrics}
+	cr := newConnReader(counted)
+	reader := request.NewReader(cr)
+	reader.Limits = s.Limits
+	reader.AllowExtensionMethods = s.AllowExtensionMethods
+	for first := true; ; first = false {
+		if !first && !s.setConnState(conn, connIdle) {
+			return
+		}
+		start := time.Now()
+		if err := s.waitForRequest(conn, reader, first); err != nil {
+			return
+		}
+		if !s.setConnState(conn, connActive) {
+			return
+		}
+
+		if !first {
+			start = time.Now()
+		}
+		conn.SetReadDeadline(deadline(start, s.readHeaderTimeout()))
+		r, err := reader.ReadRequest()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			log.Println("error reading request:", err)
+			s.metrics.observeParseError(err)
+			s.writeError(counted, nil, statusForError(err), err)
+			return
+		}
+		r.RemoteAddr = conn.RemoteAddr().String()
+		r.TLS = tlsState
+		conn.SetReadDeadline(deadline(start, s.ReadTimeout))
+		conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout))
+
+		if !s.serveRequest(connCtx, cr, reader, counted, r) {
+			return
+		}
+	}
+}
+
+// serveRequest runs the handler for r and reports whether the connection
+// can be reused for another request.
+func (s *Server) serveRequest(connCtx context.Context, cr *connReader, reader *request.Reader, conn net.Conn, r *request.Request) bool {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if s.WriteTimeout > 0 {
+		ctx, cancel = context.WithTimeout(connCtx, s.WriteTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(connCtx)
+	}
+	defer cancel()
+	r = r.WithContext(ctx)
 
-	r, err := request.RequestFromReader(conn)
+	// A hang-up can only be watched for once the request has been read in
+	// full and nothing of the next one is buffered.
+	watchHangUp := func() {
+		if reader.Buffered() == 0 {
+			cr.startBackgroundRead(cancel)
+		}
+	}
+	if r.State == request.DONE {
+		watchHangUp()
+	}
+
+	responseWriter := newResponseWriter(conn, r)
+	var ecr *expectContinueReader
+	var bodyErr error
+	if r.ExpectsContinue() && r.State != request.DONE {
+		ecr = &expectContinueReader{ReadCloser: r.Body, w: responseWriter}
+		r.Body = ecr
+	}
+	if r.State != request.DONE {
+		r.Body = &bodyReader{ReadCloser: r.Body, onDone: watchHangUp, onError: func(err error) {
+			bodyErr = err
+			s.metrics.observeParseError(err)
+		}}
+	}
+	keepAlive := r.KeepAlive()
+	if !keepAlive {
+		responseWriter.Headers.Set("Connection", "close")
+	} else if r.RequestLine.HttpVersion == "1.0" {
+		responseWriter.Headers.Set("Connection", "keep-alive")
+	}
+
+	start := time.Now()
+	panicErr := s.runHandler(responseWriter, r)
+	cr.abortPendingRead()
+	if panicErr != nil {
+		// Once the status is out the response cannot be replaced, so the
+		// connection is dropped to show the client it is incomplete.
+		status := responseWriter.StatusCode()
+		if !responseWriter.Committed() {
+			status = response.StatusInternalServerError
+			s.writeError(conn, r, status, panicErr)
+		}
+		s.metrics.observeRequest(r.RequestLine.Method, status, time.Since(start))
+		return false
+	}
+
+	// A body the handler failed to read, e.g. for being too long, is
+	// answered like a request that could not be read, unless the handler
+	// already committed its own response.
+	var parseErr *request.ParseError
+	if errors.As(bodyErr, &parseErr) && !responseWriter.Committed() {
+		status := response.StatusCode(parseErr.Status)
+		s.writeError(conn, r, status, bodyErr)
+		s.metrics.observeRequest(r.RequestLine.Method, status, time.Since(start))
+		return false
+	}
+
+	// A body never asked for with 100 Continue may or may not follow, so
+	// the next request cannot be found reliably.
+	if s.closed.Load() || (ecr != nil && !ecr.sent) {
+		keepAlive = false
+		responseWriter.Headers.Set("Connection", "close")
+	}
+
+	err := responseWriter.Finalize()
+	s.metrics.observeRequest(r.RequestLine.Method, responseWriter.StatusCode(), time.Since(start))
 	if err != nil {
-		log.Println("error reading request:", err)
-		responseWriter.WriteStatusLine(response.StatusBadRequest)
-		responseWriter.WriteHeaders()
-		return
+		log.Println("error writing response:", err)
+		return false
 	}
 
-	s.handler(responseWriter, r)
+	if !keepAlive || strings.EqualFold(responseWriter.Headers.Get("Connection"), "close") {
+		return false
+	}
+	if err := r.Body.Close(); err != nil {
+		if !errors.Is(err, request.ErrBodyNotDiscarded) {
+			log.Println("error discarding request body:", err)
+		}
+		return false
+	}
+	return true
+}
 
-	isChunked := r.Headers.Get("Transfer-Encoding") == "chunked"
-	if isChunked {
-		return
+// runHandler calls the handler chain and recovers from a panic in it. The
+// panic is logged with the request line and client address, and passed to
+// the PanicHook.
+func (s *Server) runHandler(w *response.ResponseWriter, r *request.Request) (panicErr *PanicError) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		panicErr = &PanicError{Value: value, Stack: debug.Stack()}
+		log.Printf("panic serving %s %s for %s: %v\n%s", r.RequestLine.Method, r.RequestLine.RequestTarget, r.RemoteAddr, value, panicErr.Stack)
+		if s.PanicHook != nil {
+			s.PanicHook(r, panicErr)
+		}
+	}()
+	s.chain()(w, r)
+	return nil
+}
+
+// newResponseWriter returns a writer answering r in its HTTP version, and
+// without a body if r is a HEAD request.
+func newResponseWriter(conn net.Conn, r *request.Request) *response.ResponseWriter {
+	w := response.NewResponseWriter(conn)
+	w.SetVersion(r.RequestLine.HttpVersion)
+	if r.RequestLine.Method == "HEAD" {
+		w.SuppressBody()
+	}
+	return w
+}
+
+// writeError answers a request that could not be read or handled using the
+// configured ErrorHandler. r is nil if the request could not be parsed.
+// The connection is not reused afterwards.
+func (s *Server) writeError(conn net.Conn, r *request.Request, status response.StatusCode, err error) {
+	conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout))
+	responseWriter := response.NewResponseWriter(conn)
+	if r != nil {
+		responseWriter = newResponseWriter(conn, r)
 	}
+	responseWriter.Headers.Set("Connection", "close")
+
+	errorHandler := s.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = TextErrorHandler
+	}
+	errorHandler(responseWriter, status, err)
 	responseWriter.Finalize()
 }
+
+// handshake completes the TLS handshake within the header timeout, so the
+// connection state is known before the first request is read.
+func (s *Server) handshake(ctx context.Context, conn *tls.Conn) (*tls.ConnectionState, error) {
+	conn.SetDeadline(deadline(time.Now(), s.readHeaderTimeout()))
+	defer conn.SetDeadline(time.Time{})
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	state := conn.ConnectionState()
+	return &state, nil
+}
+
+// waitForRequest blocks until the next request starts arriving. The first
+// request on a connection is bounded by the header timeout, later ones by
+// the idle timeout.
+func (s *Server) waitForRequest(conn net.Conn, reader *request.Reader, first bool) error {
+	timeout := s.readHeaderTimeout()
+	if !first {
+		timeout = s.IdleTimeout
+		if timeout == 0 {
+			timeout = s.ReadTimeout
+		}
+	}
+	conn.SetReadDeadline(deadline(time.Now(), timeout))
+	return reader.Wait()
+}
+
+func (s *Server) readHeaderTimeout() time.Duration {
+	if s.ReadHeaderTimeout != 0 {
+		return s.ReadHeaderTimeout
+	}
+	return s.ReadTimeout
+}
+
+// deadline returns the zero time, meaning no deadline, for a zero timeout.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return start.Add(timeout)
+}
@@ -0,0 +1,672 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, handler Handler) net.Conn {
+	t.Helper()
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func echoRemoteAddrHandler(w *response.ResponseWriter, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	w.Write([]byte(req.RemoteAddr))
+}
+
+func echoTargetHandler(w *response.ResponseWriter, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	w.Write([]byte(req.RequestLine.RequestTarget))
+}
+
+func TestKeepAlive(t *testing.T) {
+	conn := startServer(t, echoTargetHandler)
+	reader := bufio.NewReader(conn)
+
+	for _, target := range []string{"/one", "/two", "/three"} {
+		_, err := conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		res, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, target, string(body))
+		assert.False(t, res.Close)
+	}
+
+	// Test: A large unread body is not drained to keep the connection
+	_, err := conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1073741824\r\n\r\n"))
+	require.NoError(t, err)
+	go conn.Write(make([]byte, 2*request.MAX_DISCARD_BYTES))
+	res, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	io.ReadAll(res.Body)
+	// Returns once the server closes the connection, with a reset if the
+	// rest of the upload was still in flight.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.Copy(io.Discard, reader)
+	assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestRemoteAddr(t *testing.T) {
+	conn := startServer(t, echoRemoteAddrHandler)
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, conn.LocalAddr().String(), string(body))
+}
+
+func TestPipelining(t *testing.T) {
+	conn := startServer(t, echoTargetHandler)
+	reader := bufio.NewReader(conn)
+
+	_, err := conn.Write([]byte(
+		"POST /one HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	for _, target := range []string{"/one", "/two", "/three"} {
+		res, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, target, string(body))
+	}
+
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Headers sent by the handler without a length stay framed
+	conn = startServer(t, func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteHeaders()
+		w.Write([]byte("hello " + req.RequestLine.RequestTarget))
+	})
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte(
+		"GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /c HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	require.NoError(t, err)
+	for _, target := range []string{"/a", "/b", "/c"} {
+		res, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello "+target, string(body))
+	}
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestMiddleware(t *testing.T) {
+	var order []string
+	var observedStatus response.StatusCode
+	var observedBytes int
+
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.ResponseWriter, req *request.Request) {
+				order = append(order, name+" before")
+				next(w, req)
+				order = append(order, name+" after")
+			}
+		}
+	}
+	observe := func(next Handler) Handler {
+		return func(w *response.ResponseWriter, req *request.Request) {
+			next(w, req)
+			observedStatus = w.StatusCode()
+			observedBytes = w.BytesWritten()
+		}
+	}
+
+	handler := Chain(func(w *response.ResponseWriter, req *request.Request) {
+		order = append(order, "handler")
+		w.WriteStatusLine(response.StatusNotFound)
+		w.Write([]byte("missing"))
+	}, trace("outer"), trace("inner"))
+
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+	s.Use(observe)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 404, res.StatusCode)
+
+	assert.Equal(t, []string{"outer before", "inner before", "handler", "inner after", "outer after"}, order)
+	assert.Equal(t, response.StatusNotFound, observedStatus)
+	assert.Equal(t, 7, observedBytes)
+}
+
+func TestTimeouts(t *testing.T) {
+	s, err := Serve(0, echoTargetHandler,
+		WithReadHeaderTimeout(100*time.Millisecond),
+		WithIdleTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Slow headers get a 408
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: loc"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 408, res.StatusCode)
+	assert.True(t, res.Close)
+
+	// Test: Idle keep-alive connection is closed
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /idle HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader = bufio.NewReader(conn)
+	res, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	_, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.ResponseWriter, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.Write([]byte("done"))
+	})
+	require.NoError(t, err)
+	addr := s.Addr().String()
+
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = idle.Write([]byte("GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	idleReader := bufio.NewReader(idle)
+	res, err := http.ReadResponse(idleReader, nil)
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	active, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer active.Close()
+	_, err = active.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	fresh, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer fresh.Close()
+	require.Eventually(t, func() bool {
+		s.connsMu.Lock()
+		defer s.connsMu.Unlock()
+		return len(s.conns) == 3
+	}, time.Second, time.Millisecond)
+
+	shutdownDone := make(chan error)
+	go func() {
+		shutdownDone <- s.Shutdown(context.Background())
+	}()
+
+	// Test: Idle keep-alive connection is closed right away
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = idleReader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: A connection accepted before shutdown still gets its first request served
+	_, err = fresh.Write([]byte("GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(fresh), nil)
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.True(t, res.Close)
+
+	// Test: Shutdown waits for the active request
+	select {
+	case <-shutdownDone:
+		t.Fatal("shutdown returned with a request in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	res, err = http.ReadResponse(bufio.NewReader(active), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "done", string(body))
+	assert.True(t, res.Close)
+	require.NoError(t, <-shutdownDone)
+
+	// Test: New connections are refused
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	started := make(chan struct{})
+	s, err := Serve(0, func(w *response.ResponseWriter, req *request.Request) {
+		close(started)
+		time.Sleep(time.Second)
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+type ctxKey struct{}
+
+func TestRequestContext(t *testing.T) {
+	cancelled := make(chan error, 1)
+	started := make(chan struct{}, 1)
+	withValue := func(next Handler) Handler {
+		return func(w *response.ResponseWriter, req *request.Request) {
+			next(w, req.WithContext(context.WithValue(req.Context(), ctxKey{}, "request-scoped")))
+		}
+	}
+	handler := Chain(func(w *response.ResponseWriter, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/value" {
+			w.WriteStatusLine(response.StatusOK)
+			w.Write([]byte(req.Context().Value(ctxKey{}).(string)))
+			return
+		}
+		io.ReadAll(req.Body)
+		started <- struct{}{}
+		select {
+		case <-req.Context().Done():
+			cancelled <- req.Context().Err()
+		case <-time.After(time.Second):
+			cancelled <- nil
+		}
+	}, withValue)
+
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Middleware can attach values
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /value HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "request-scoped", string(body))
+
+	// Test: Client hang-up cancels the context
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET /wait HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+	conn.Close()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	// Test: Hang-up is noticed once the handler has read the whole body
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("POST /wait HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	<-started
+	conn.Close()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+}
+
+func TestRequestContextCancelledOnClose(t *testing.T) {
+	cancelled := make(chan error, 1)
+	started := make(chan struct{})
+	s, err := Serve(0, func(w *response.ResponseWriter, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+		cancelled <- req.Context().Err()
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	<-started
+
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+}
+
+func TestLimitResponses(t *testing.T) {
+	readBody := func(w *response.ResponseWriter, req *request.Request) {
+		body, _ := io.ReadAll(req.Body)
+		w.WriteStatusLine(response.StatusOK)
+		w.Write([]byte("got " + string(body)))
+	}
+	s, err := Serve(0, readBody, WithLimits(request.Limits{
+		MaxRequestLineBytes: 64,
+		MaxHeaderLineBytes:  64,
+		MaxBodyBytes:        4,
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	cases := []struct {
+		name   string
+		data   string
+		status int
+	}{
+		{"uri too long", "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\n\r\n", 414},
+		{"header too large", "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 100) + "\r\n\r\n", 431},
+		{"body too large", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello", 413},
+		{"chunked body too large", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n3\r\ndef\r\n0\r\n\r\n", 413},
+		{"malformed chunk", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", 400},
+		{"malformed", "GET /\r\n\r\n", 400},
+		{"missing host", "GET / HTTP/1.1\r\n\r\n", 400},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", s.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+			_, err = conn.Write([]byte(tc.data))
+			require.NoError(t, err)
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, res.StatusCode)
+			assert.True(t, res.Close)
+		})
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	send := func(t *testing.T, s *Server, data string) (*http.Response, string) {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(data))
+		require.NoError(t, err)
+
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res, string(body)
+	}
+
+	// Test: Default plain text responses
+	s, err := Serve(0, echoTargetHandler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	res, body := send(t, s, "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 501, res.StatusCode)
+	assert.Equal(t, "501 Not Implemented: unsupported http method\n", body)
+
+	res, _ = send(t, s, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, 505, res.StatusCode)
+
+	// Test: problem+json responses
+	s, err = Serve(0, echoTargetHandler, WithErrorHandler(ProblemJSONErrorHandler))
+	require.NoError(t, err)
+	defer s.Close()
+
+	res, body = send(t, s, "GET / HTTP/1.1\r\nBroken header\r\n\r\n")
+	assert.Equal(t, 400, res.StatusCode)
+	assert.Equal(t, "application/problem+json", res.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"malformed header","state":"parsing headers","offset":16}`, body)
+}
+
+func TestMethods(t *testing.T) {
+	conn := startServer(t, echoTargetHandler)
+	reader := bufio.NewReader(conn)
+
+	// Test: HEAD keeps Content-Length but sends no body
+	_, err := conn.Write([]byte("HEAD /head HTTP/1.1\r\nHost: localhost\r\n\r\nGET /get HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("/head")), res.ContentLength)
+	res, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "/get", string(body))
+
+	// Test: Extension methods are refused by default
+	_, err = conn.Write([]byte("PURGE /cache HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 501, res.StatusCode)
+
+	// Test: Extension methods can be enabled
+	s, err := Serve(0, echoTargetHandler, WithExtensionMethods())
+	require.NoError(t, err)
+	defer s.Close()
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PURGE /cache HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: The connection is closed by default
+	conn := startServer(t, echoTargetHandler)
+	_, err := conn.Write([]byte("GET /old HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.0 200 OK\r\n"))
+	assert.Contains(t, string(raw), "Connection: close\r\n")
+
+	// Test: Keep-alive is honoured when asked for
+	conn = startServer(t, echoTargetHandler)
+	reader := bufio.NewReader(conn)
+	for _, target := range []string{"/one", "/two"} {
+		_, err := conn.Write([]byte("GET " + target + " HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+		require.NoError(t, err)
+		res, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, target, string(body))
+		assert.Equal(t, "keep-alive", res.Header.Get("Connection"))
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	echoBody := func(w *response.ResponseWriter, req *request.Request) {
+		if req.Headers.Get("X-Reject") != "" {
+			w.WriteStatusLine(response.StatusContentTooLarge)
+			return
+		}
+		body, _ := req.ReadBody()
+		w.WriteStatusLine(response.StatusOK)
+		w.Write(body)
+	}
+
+	// Test: 100 Continue is sent once the handler reads the body
+	conn := startServer(t, echoBody)
+	reader := bufio.NewReader(conn)
+	_, err := conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	interim := make([]byte, len("HTTP/1.1 100 Continue\r\n\r\n"))
+	_, err = io.ReadFull(reader, interim)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", string(interim))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.False(t, res.Close)
+
+	// Test: Handlers that write the status before reading the body
+	conn = startServer(t, func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteStatusLine(response.StatusCreated)
+		w.Headers.Set("X-Handler", "status-first")
+		body, _ := req.ReadBody()
+		w.Write(body)
+	})
+	reader = bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(reader, interim)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", string(interim))
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Equal(t, "status-first", res.Header.Get("X-Handler"))
+	assert.Equal(t, "hello", string(body))
+
+	// Test: The handler can reject the request without reading the body
+	conn = startServer(t, echoBody)
+	_, err = conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\nX-Reject: 1\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 413, res.StatusCode)
+	assert.True(t, res.Close)
+
+	// Test: Unknown expectations get 417
+	conn = startServer(t, echoBody)
+	_, err = conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: teapot\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 417, res.StatusCode)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	handler := func(w *response.ResponseWriter, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/late" {
+			w.WriteStatusLine(response.StatusOK)
+			w.Write([]byte("partial"))
+			w.Flush()
+		}
+		panic("boom")
+	}
+	hooked := make(chan string, 2)
+	s, err := Serve(0, handler, WithPanicHook(func(req *request.Request, err *PanicError) {
+		hooked <- req.RequestLine.RequestTarget + " " + err.Error()
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: A panic before the status is sent becomes a 500
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /early HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 500, res.StatusCode)
+	assert.Equal(t, "500 Internal Server Error\n", string(body))
+	assert.True(t, res.Close)
+	assert.Equal(t, "/early panic: boom", <-hooked)
+
+	// Test: A panic after the status is sent aborts the connection
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	_, err = io.ReadAll(res.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "/late panic: boom", <-hooked)
+
+	// Test: The 500 matches the version and method of the request
+	conn, err = net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("HEAD /early HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.0 500 Internal Server Error\r\n"))
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\n"))
+	assert.NotContains(t, string(raw), "500 Internal Server Error\n")
+	assert.Equal(t, "/early panic: boom", <-hooked)
+}
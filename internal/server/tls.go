@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// certCheckInterval is how often at most the certificate files are checked
+// for changes, so that handshakes do not wait on the filesystem.
+const certCheckInterval = time.Second
+
+type certFiles struct {
+	certFile string
+	keyFile  string
+}
+
+// certStore serves certificates loaded from files. It picks one by the
+// server name the client asks for and reloads a pair when either of its
+// files changes on disk, so certificates can be renewed without a restart.
+type certStore struct {
+	certs []*storedCert
+	// nextCheck is when the files are due to be checked again, in Unix
+	// nanoseconds. reloadMu keeps slow checks from overlapping.
+	nextCheck atomic.Int64
+	reloadMu  sync.Mutex
+
+	mu sync.RWMutex
+}
+
+type storedCert struct {
+	certFiles
+	modTime time.Time
+	cert    *tls.Certificate
+}
+
+func newCertStore(files []certFiles) (*certStore, error) {
+	cs := &certStore{}
+	for _, f := range files {
+		sc := &storedCert{certFiles: f}
+		modTime, err := sc.latestModTime()
+		if err != nil {
+			return nil, err
+		}
+		if err := sc.load(modTime); err != nil {
+			return nil, err
+		}
+		cs.certs = append(cs.certs, sc)
+	}
+	cs.nextCheck.Store(time.Now().Add(certCheckInterval).UnixNano())
+	return cs, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. The first
+// certificate valid for the requested server name is used, or the first
+// one overall if none is.
+func (cs *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.maybeReload()
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for _, sc := range cs.certs {
+		if hello.SupportsCertificate(sc.cert) == nil {
+			return sc.cert, nil
+		}
+	}
+	return cs.certs[0].cert, nil
+}
+
+// maybeReload checks the files for changes if certCheckInterval has passed
+// since the last check. Only the handshake that claims the check pays for
+// it; the others go on with the certificates already loaded.
+func (cs *certStore) maybeReload() {
+	now := time.Now().UnixNano()
+	next := cs.nextCheck.Load()
+	if now < next || !cs.nextCheck.CompareAndSwap(next, now+int64(certCheckInterval)) {
+		return
+	}
+	if !cs.reloadMu.TryLock() {
+		return
+	}
+	defer cs.reloadMu.Unlock()
+
+	for _, sc := range cs.certs {
+		cert, err := sc.reload()
+		if err != nil {
+			log.Println("error reloading certificate:", err)
+			continue
+		}
+		if cert != nil {
+			cs.mu.Lock()
+			sc.cert = cert
+			cs.mu.Unlock()
+		}
+	}
+}
+
+// reload loads the pair again if either file changed since the last
+// attempt, returning nil if neither did. On failure the previous
+// certificate stays in use, which covers files caught halfway through
+// being replaced; the next change retries.
+func (sc *storedCert) reload() (*tls.Certificate, error) {
+	modTime, err := sc.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if modTime.Equal(sc.modTime) {
+		return nil, nil
+	}
+	sc.modTime = modTime
+	cert, err := tls.LoadX509KeyPair(sc.certFile, sc.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (sc *storedCert) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(sc.certFile, sc.keyFile)
+	if err != nil {
+		return err
+	}
+	sc.cert, sc.modTime = &cert, modTime
+	return nil
+}
+
+func (sc *storedCert) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{sc.certFile, sc.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSigned creates a certificate for name that is its own CA.
+func selfSigned(t *testing.T, name string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeCert stores cert as PEM files in dir and returns their paths.
+func writeCert(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func tlsInfoHandler(w *response.ResponseWriter, req *request.Request) {
+	w.WriteStatusLine(response.StatusOK)
+	fmt.Fprintf(w, "%s %s %d", tls.VersionName(req.TLS.Version), req.TLS.ServerName, len(req.TLS.PeerCertificates))
+}
+
+// getTLS sends a request over a new TLS connection and returns the body
+// together with the certificate the server presented.
+func getTLS(t *testing.T, s *Server, config *tls.Config) (string, *x509.Certificate) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.Addr().String(), config)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + config.ServerName + "\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body), conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certA := selfSigned(t, "a.test")
+	certB := selfSigned(t, "b.test")
+	certFileA, keyFileA := writeCert(t, dir, "a", certA)
+	certFileB, keyFileB := writeCert(t, dir, "b", certB)
+
+	s, err := ServeTLS(0, tlsInfoHandler, certFileA, keyFileA, WithCertificate(certFileB, keyFileB))
+	require.NoError(t, err)
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certA.Leaf)
+	roots.AddCert(certB.Leaf)
+
+	// Test: Connection state is exposed on the request
+	body, peer := getTLS(t, s, &tls.Config{ServerName: "a.test", RootCAs: roots, MinVersion: tls.VersionTLS13})
+	assert.Equal(t, "TLS 1.3 a.test 0", body)
+	assert.Equal(t, certA.Leaf.SerialNumber, peer.SerialNumber)
+
+	// Test: The certificate is picked by SNI
+	_, peer = getTLS(t, s, &tls.Config{ServerName: "b.test", RootCAs: roots})
+	assert.Equal(t, certB.Leaf.SerialNumber, peer.SerialNumber)
+
+	// Test: Certificates are reloaded when the files change
+	renewed := selfSigned(t, "a.test")
+	writeCert(t, dir, "a", renewed)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFileA, later, later))
+	roots.AddCert(renewed.Leaf)
+	assert.Eventually(t, func() bool {
+		_, peer = getTLS(t, s, &tls.Config{ServerName: "a.test", RootCAs: roots})
+		return peer.SerialNumber.Cmp(renewed.Leaf.SerialNumber) == 0
+	}, 3*certCheckInterval, 100*time.Millisecond)
+}
+
+func TestServeTLSClientCertificates(t *testing.T) {
+	serverCert := selfSigned(t, "mtls.test")
+	clientCert := selfSigned(t, "client")
+	clients := x509.NewCertPool()
+	clients.AddCert(clientCert.Leaf)
+
+	s, err := ServeTLS(0, tlsInfoHandler, "", "", WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert.Leaf)
+	body, _ := getTLS(t, s, &tls.Config{ServerName: "mtls.test", RootCAs: roots, Certificates: []tls.Certificate{clientCert}})
+	assert.Equal(t, "TLS 1.3 mtls.test 1", body)
+
+	// Test: A certificate is required
+	_, err = ServeTLS(0, tlsInfoHandler, "", "")
+	require.Error(t, err)
+}
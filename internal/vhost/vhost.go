@@ -0,0 +1,108 @@
+package vhost
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/crunchydeer30/httpfromtcp/internal/server"
+)
+
+type wildcard struct {
+	suffix  string
+	handler server.Handler
+}
+
+// Hosts dispatches requests to handlers by the host they are addressed to.
+// Its ServeHTTP method is a server.Handler.
+type Hosts struct {
+	exact     map[string]server.Handler
+	wildcards []wildcard
+	fallback  server.Handler
+}
+
+func New() *Hosts {
+	return &Hosts{exact: map[string]server.Handler{}}
+}
+
+// Handle registers handler for pattern. A pattern is a hostname such as
+// "tools.example.test", a wildcard such as "*.example.test" that matches
+// any subdomain but not example.test itself, or "*" for requests no other
+// pattern matches. Exact names win over wildcards and longer wildcards
+// over shorter ones. Handle panics if the pattern is malformed or already
+// registered.
+func (h *Hosts) Handle(pattern string, handler server.Handler) {
+	if pattern == "*" {
+		if h.fallback != nil {
+			panic(fmt.Sprintf("vhost: pattern %q is already registered", pattern))
+		}
+		h.fallback = handler
+		return
+	}
+
+	name := hostname(pattern)
+	if name == "" || name != strings.ToLower(pattern) || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+		panic(fmt.Sprintf("vhost: pattern %q is malformed", pattern))
+	}
+
+	if suffix, found := strings.CutPrefix(name, "*"); found {
+		for _, w := range h.wildcards {
+			if w.suffix == suffix {
+				panic(fmt.Sprintf("vhost: pattern %q is already registered", pattern))
+			}
+		}
+		h.wildcards = append(h.wildcards, wildcard{suffix: suffix, handler: handler})
+		return
+	}
+
+	if _, ok := h.exact[name]; ok {
+		panic(fmt.Sprintf("vhost: pattern %q is already registered", pattern))
+	}
+	h.exact[name] = handler
+}
+
+// ServeHTTP dispatches req by req.Host(). Requests for a host nobody
+// handles get 421 Misdirected Request.
+func (h *Hosts) ServeHTTP(w *response.ResponseWriter, req *request.Request) {
+	handler := h.match(hostname(req.Host()))
+	if handler == nil {
+		w.WriteStatusLine(response.StatusMisdirectedRequest)
+		w.Write([]byte("421 misdirected request\n"))
+		return
+	}
+	handler(w, req)
+}
+
+func (h *Hosts) match(name string) server.Handler {
+	if handler, ok := h.exact[name]; ok {
+		return handler
+	}
+
+	var best *wildcard
+	for i, w := range h.wildcards {
+		if len(name) <= len(w.suffix) || !strings.HasSuffix(name, w.suffix) {
+			continue
+		}
+		if best == nil || len(w.suffix) > len(best.suffix) {
+			best = &h.wildcards[i]
+		}
+	}
+	if best != nil {
+		return best.handler
+	}
+	return h.fallback
+}
+
+// hostname strips the port and any trailing dot from host and lowercases
+// it, since host names are case-insensitive.
+func hostname(host string) string {
+	if strings.HasPrefix(host, "[") {
+		if end := strings.IndexByte(host, ']'); end != -1 {
+			return strings.ToLower(host[:end+1])
+		}
+		return strings.ToLower(host)
+	}
+	name, _, _ := strings.Cut(host, ":")
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,89 @@
+package vhost
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/crunchydeer30/httpfromtcp/internal/request"
+	"github.com/crunchydeer30/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, h *Hosts, host string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	w := response.NewResponseWriter(buf)
+	h.ServeHTTP(w, req)
+	require.NoError(t, w.Finalize())
+
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func writeText(text string) func(w *response.ResponseWriter, req *request.Request) {
+	return func(w *response.ResponseWriter, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.Write([]byte(text))
+	}
+}
+
+func TestHostsMatching(t *testing.T) {
+	h := New()
+	h.Handle("example.test", writeText("apex"))
+	h.Handle("Admin.Example.Test", writeText("admin"))
+	h.Handle("*.example.test", writeText("any"))
+	h.Handle("*.eu.example.test", writeText("eu"))
+
+	// Test: Exact match ignores case, port and trailing dot
+	_, body := serve(t, h, "ADMIN.example.test:8080")
+	assert.Equal(t, "admin", body)
+	_, body = serve(t, h, "example.test.")
+	assert.Equal(t, "apex", body)
+
+	// Test: Wildcards match subdomains at any depth
+	_, body = serve(t, h, "docs.example.test")
+	assert.Equal(t, "any", body)
+	_, body = serve(t, h, "a.b.example.test")
+	assert.Equal(t, "any", body)
+
+	// Test: The longest wildcard wins
+	_, body = serve(t, h, "paris.eu.example.test")
+	assert.Equal(t, "eu", body)
+
+	// Test: Unknown hosts are misdirected
+	res, _ := serve(t, h, "other.test")
+	assert.Equal(t, 421, res.StatusCode)
+	res, _ = serve(t, h, "badexample.test")
+	assert.Equal(t, 421, res.StatusCode)
+
+	// Test: Fallback handler
+	h.Handle("*", writeText("fallback"))
+	_, body = serve(t, h, "other.test")
+	assert.Equal(t, "fallback", body)
+}
+
+func TestHostsInvalidPatterns(t *testing.T) {
+	h := New()
+	h.Handle("example.test", writeText("a"))
+	h.Handle("*.example.test", writeText("a"))
+	h.Handle("*", writeText("a"))
+
+	assert.Panics(t, func() { h.Handle("EXAMPLE.test", writeText("a")) })
+	assert.Panics(t, func() { h.Handle("*.example.test", writeText("a")) })
+	assert.Panics(t, func() { h.Handle("*", writeText("a")) })
+	assert.Panics(t, func() { h.Handle("", writeText("a")) })
+	assert.Panics(t, func() { h.Handle("example.test:80", writeText("a")) })
+	assert.Panics(t, func() { h.Handle("*example.test", writeText("a")) })
+	assert.Panics(t, func() { h.Handle("a.*.example.test", writeText("a")) })
+}